@@ -3,13 +3,14 @@ package account
 import (
 	"encoding/json"
 	"fmt"
+	"gamechain/coin"
 	"os"
 	"sync"
 )
 
 // AccountBalance 代表单个账户的余额和锁
 type AccountBalance struct {
-	Balance float64
+	Balance coin.Amount
 	Mu      sync.RWMutex
 }
 
@@ -28,7 +29,7 @@ func NewBalanceManager() *BalanceManager {
 }
 
 // SetBalance 设置账户余额
-func (bm *BalanceManager) SetBalance(account string, balance float64) {
+func (bm *BalanceManager) SetBalance(account string, balance coin.Amount) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
@@ -43,7 +44,7 @@ func (bm *BalanceManager) SetBalance(account string, balance float64) {
 }
 
 // GetBalance 获取账户余额
-func (bm *BalanceManager) GetBalance(account string) (float64, bool) {
+func (bm *BalanceManager) GetBalance(account string) (coin.Amount, bool) {
 	bm.mu.RLock()
 	ab, exists := bm.balances[account]
 	bm.mu.RUnlock()
@@ -57,56 +58,6 @@ func (bm *BalanceManager) GetBalance(account string) (float64, bool) {
 	return ab.Balance, true
 }
 
-// AddBalance 增加账户余额
-func (bm *BalanceManager) AddBalance(account string, amount float64, filePath string) {
-	bm.mu.Lock()
-	if bm.balances[account] == nil {
-		bm.balances[account] = &AccountBalance{}
-	}
-	ab := bm.balances[account]
-	bm.mu.Unlock()
-
-	ab.Mu.Lock()
-	ab.Balance += amount
-	ab.Mu.Unlock()
-
-	go bm.saveBalancesAsync(filePath)
-}
-
-// DeductBalance 扣减账户余额
-func (bm *BalanceManager) DeductBalance(account string, amount float64, filePath string) bool {
-	bm.mu.RLock()
-	ab, exists := bm.balances[account]
-	bm.mu.RUnlock()
-
-	if !exists {
-		fmt.Printf("账户 %s 不存在\n", account)
-		return false
-	}
-
-	ab.Mu.Lock()
-	defer ab.Mu.Unlock()
-
-	if ab.Balance >= amount {
-		ab.Balance -= amount
-		go bm.saveBalancesAsync(filePath)
-		return true
-	}
-
-	fmt.Println("余额不足")
-	return false
-}
-
-// saveBalancesAsync 异步保存余额到文件
-func (bm *BalanceManager) saveBalancesAsync(filePath string) {
-	err := bm.SaveBalances(filePath)
-	if err != nil {
-		fmt.Printf("保存余额失败: %v\n", err)
-	} else {
-		fmt.Println("余额已成功保存")
-	}
-}
-
 // SaveBalances 保存所有账户余额到文件
 func (bm *BalanceManager) SaveBalances(filePath string) error {
 	bm.writeMu.Lock()
@@ -115,7 +66,7 @@ func (bm *BalanceManager) SaveBalances(filePath string) error {
 	bm.mu.RLock()
 	defer bm.mu.RUnlock()
 
-	data := make(map[string]float64)
+	data := make(map[string]coin.Amount)
 	for account, ab := range bm.balances {
 		ab.Mu.RLock()
 		data[account] = ab.Balance
@@ -145,7 +96,7 @@ func (bm *BalanceManager) LoadBalances(filePath string) error {
 		return fmt.Errorf("读取文件失败: %w", err)
 	}
 
-	var balances map[string]float64
+	var balances map[string]coin.Amount
 	if err = json.Unmarshal(data, &balances); err != nil {
 		return fmt.Errorf("解析文件失败: %w", err)
 	}
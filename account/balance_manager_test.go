@@ -1,6 +1,7 @@
 package account
 
 import (
+	"gamechain/coin"
 	"os"
 	"testing"
 )
@@ -15,8 +16,8 @@ func TestSaveBalances(t *testing.T) {
 
 	// 初始化 BalanceManager 并设置初始数据
 	bm := NewBalanceManager()
-	bm.SetBalance("account1", 100.0)
-	bm.SetBalance("account2", 200.0)
+	bm.SetBalance("account1", 100*coin.Coin)
+	bm.SetBalance("account2", 200*coin.Coin)
 
 	// 调用 SaveBalances 方法
 	err := bm.SaveBalances(tempFilePath)
@@ -0,0 +1,109 @@
+package account
+
+import (
+	"fmt"
+	"gamechain/coin"
+)
+
+// SystemAccount 奖励交易的发送方，不是真实账户，不记录余额
+const SystemAccount = "System"
+
+// Transfer 描述区块中一笔交易对余额的影响：From 支付 Amount+Fee，To 收到 Amount。
+// From 为 SystemAccount 的奖励交易只增加 To 的余额
+type Transfer struct {
+	From   string
+	To     string
+	Amount coin.Amount
+	Fee    coin.Amount
+}
+
+// ApplyBlock 在区块接入主链时按顺序执行其中的转账。
+// 任一转账导致余额为负或溢出时返回错误，且不改变任何账户的余额
+func (bm *BalanceManager) ApplyBlock(transfers []Transfer) error {
+	return bm.applyTransfers(transfers, false)
+}
+
+// RevertBlock 在区块从主链断开时按相反顺序撤销 ApplyBlock 执行过的转账
+func (bm *BalanceManager) RevertBlock(transfers []Transfer) error {
+	return bm.applyTransfers(transfers, true)
+}
+
+// Reset 清空全部账户，并以给定的余额重新开始（例如从创世状态重建余额）
+func (bm *BalanceManager) Reset(balances map[string]coin.Amount) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.balances = make(map[string]*AccountBalance, len(balances))
+	for account, balance := range balances {
+		bm.balances[account] = &AccountBalance{Balance: balance}
+	}
+}
+
+// applyTransfers 先在副本上计算全部转账的结果，全部成功后再一次性写入
+func (bm *BalanceManager) applyTransfers(transfers []Transfer, revert bool) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	updated := make(map[string]coin.Amount)
+	adjust := func(account string, delta coin.Amount) error {
+		if account == SystemAccount {
+			return nil
+		}
+		balance, exists := updated[account]
+		if !exists {
+			if ab := bm.balances[account]; ab != nil {
+				ab.Mu.RLock()
+				balance = ab.Balance
+				ab.Mu.RUnlock()
+			}
+		}
+		balance, err := balance.Add(delta)
+		if err != nil {
+			return fmt.Errorf("账户 %s 余额溢出: %w", account, err)
+		}
+		if balance < 0 {
+			return fmt.Errorf("账户 %s 余额不足", account)
+		}
+		updated[account] = balance
+		return nil
+	}
+
+	for i := range transfers {
+		t := transfers[i]
+		if revert {
+			t = transfers[len(transfers)-1-i]
+		}
+		if t.Amount < 0 || t.Fee < 0 {
+			return fmt.Errorf("转账金额或手续费为负: %s -> %s", t.From, t.To)
+		}
+		cost, err := t.Amount.Add(t.Fee)
+		if err != nil {
+			return fmt.Errorf("转账金额溢出: %w", err)
+		}
+		if revert {
+			err = adjust(t.To, -t.Amount)
+			if err == nil {
+				err = adjust(t.From, cost)
+			}
+		} else {
+			err = adjust(t.From, -cost)
+			if err == nil {
+				err = adjust(t.To, t.Amount)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for account, balance := range updated {
+		if bm.balances[account] == nil {
+			bm.balances[account] = &AccountBalance{}
+		}
+		ab := bm.balances[account]
+		ab.Mu.Lock()
+		ab.Balance = balance
+		ab.Mu.Unlock()
+	}
+	return nil
+}
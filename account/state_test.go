@@ -0,0 +1,57 @@
+package account
+
+import (
+	"gamechain/coin"
+	"testing"
+)
+
+func TestApplyAndRevertBlock(t *testing.T) {
+	bm := NewBalanceManager()
+	bm.Reset(map[string]coin.Amount{"Alice": 100 * coin.Coin, "Bob": 100 * coin.Coin})
+
+	block := []Transfer{
+		{From: "Alice", To: "Bob", Amount: 30 * coin.Coin, Fee: coin.Coin},
+		{From: "Bob", To: "Carol", Amount: 120 * coin.Coin},
+		{From: SystemAccount, To: "Miner", Amount: 51 * coin.Coin},
+	}
+	if err := bm.ApplyBlock(block); err != nil {
+		t.Fatalf("ApplyBlock 失败: %v", err)
+	}
+	want := map[string]coin.Amount{"Alice": 69 * coin.Coin, "Bob": 10 * coin.Coin, "Carol": 120 * coin.Coin, "Miner": 51 * coin.Coin}
+	for name, balance := range want {
+		if got, _ := bm.GetBalance(name); got != balance {
+			t.Errorf("%s 的余额为 %s, 期望 %s", name, got, balance)
+		}
+	}
+	if _, exists := bm.GetBalance(SystemAccount); exists {
+		t.Error("System 账户不应记录余额")
+	}
+
+	if err := bm.RevertBlock(block); err != nil {
+		t.Fatalf("RevertBlock 失败: %v", err)
+	}
+	for name, balance := range map[string]coin.Amount{"Alice": 100 * coin.Coin, "Bob": 100 * coin.Coin, "Carol": 0, "Miner": 0} {
+		if got, _ := bm.GetBalance(name); got != balance {
+			t.Errorf("撤销后 %s 的余额为 %s, 期望 %s", name, got, balance)
+		}
+	}
+}
+
+func TestApplyBlockIsAtomic(t *testing.T) {
+	bm := NewBalanceManager()
+	bm.Reset(map[string]coin.Amount{"Alice": 10 * coin.Coin})
+
+	err := bm.ApplyBlock([]Transfer{
+		{From: "Alice", To: "Bob", Amount: 5 * coin.Coin},
+		{From: "Alice", To: "Bob", Amount: 6 * coin.Coin},
+	})
+	if err == nil {
+		t.Fatal("余额不足的区块应返回错误")
+	}
+	if got, _ := bm.GetBalance("Alice"); got != 10*coin.Coin {
+		t.Errorf("失败后 Alice 的余额应保持 10, 实际 %s", got)
+	}
+	if _, exists := bm.GetBalance("Bob"); exists {
+		t.Error("失败后不应创建 Bob 的余额")
+	}
+}
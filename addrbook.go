@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 地址簿参数
+const (
+	maxAddrBookSize   = 2000           // 地址簿最多记录的地址数，已满时淘汰最久未见的地址
+	maxAddrPerMessage = 1000           // 每条 addr 消息最多包含的地址数
+	banThreshold      = 100            // 惩罚分达到该值时封禁节点
+	banDuration       = 24 * time.Hour // 封禁时长
+	retryBaseDelay    = time.Minute    // 连接失败后的重试间隔，每多失败一次加倍
+	maxRetryDelay     = time.Hour      // 重试间隔的上限
+)
+
+// KnownAddress 地址簿中记录的节点地址
+type KnownAddress struct {
+	Addr        string `json:"addr"`
+	LastSeen    int64  `json:"last_seen"`    // 最近一次与该节点会话或从其他节点得知该地址的时间（Unix 秒）
+	LastAttempt int64  `json:"last_attempt"` // 最近一次尝试连接的时间（Unix 秒）
+	Failures    int    `json:"failures"`     // 连续连接失败的次数
+}
+
+// BanRecord 按 IP 记录的惩罚分。对方声明的地址和端口都可以随意更换，因此按连接的对端 IP 惩罚和封禁
+type BanRecord struct {
+	Host        string `json:"host"`
+	BanScore    int    `json:"ban_score"`    // 惩罚分，节点发送无效消息时增加
+	BannedUntil int64  `json:"banned_until"` // 封禁结束的时间（Unix 秒），未被封禁时为 0
+}
+
+// addrBookFile peers.json 中保存的内容
+type addrBookFile struct {
+	Addrs []*KnownAddress `json:"addrs"`
+	Bans  []*BanRecord    `json:"bans"`
+}
+
+// retryAt 返回下次可以尝试连接的时间，连续失败越多间隔越长
+func (a *KnownAddress) retryAt() int64 {
+	if a.Failures == 0 {
+		return a.LastAttempt
+	}
+	delay := retryBaseDelay << min(a.Failures-1, 6)
+	return a.LastAttempt + int64(min(delay, maxRetryDelay)/time.Second)
+}
+
+// AddrBook 已知节点地址的地址簿，保存在 peers.json 中，节点重启后据此重新连接网络
+type AddrBook struct {
+	mu    sync.Mutex
+	path  string // 保存的文件路径，为空时不保存
+	addrs map[string]*KnownAddress
+	bans  map[string]*BanRecord // 按 IP 索引
+	dirty bool                  // 是否有尚未保存的修改
+}
+
+// NewAddrBook 创建保存到 path 的空地址簿
+func NewAddrBook(path string) *AddrBook {
+	return &AddrBook{path: path, addrs: make(map[string]*KnownAddress), bans: make(map[string]*BanRecord)}
+}
+
+// LoadAddrBook 从文件加载地址簿，文件不存在时返回空地址簿
+func LoadAddrBook(path string) (*AddrBook, error) {
+	book := NewAddrBook(path)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取地址簿失败: %w", err)
+	}
+	var file addrBookFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析地址簿 %s 失败: %w", path, err)
+	}
+	for _, addr := range file.Addrs {
+		if validPeerAddr(addr.Addr) {
+			book.addrs[addr.Addr] = addr
+		}
+	}
+	for _, ban := range file.Bans {
+		book.bans[ban.Host] = ban
+	}
+	return book, nil
+}
+
+// Save 将地址簿按地址排序写入文件，没有修改时不写
+func (b *AddrBook) Save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.path == "" || !b.dirty {
+		return nil
+	}
+	file := addrBookFile{Addrs: make([]*KnownAddress, 0, len(b.addrs)), Bans: make([]*BanRecord, 0, len(b.bans))}
+	for _, addr := range b.addrs {
+		file.Addrs = append(file.Addrs, addr)
+	}
+	for _, ban := range b.bans {
+		file.Bans = append(file.Bans, ban)
+	}
+	sort.Slice(file.Addrs, func(i, j int) bool { return file.Addrs[i].Addr < file.Addrs[j].Addr })
+	sort.Slice(file.Bans, func(i, j int) bool { return file.Bans[i].Host < file.Bans[j].Host })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.path, data, 0644); err != nil {
+		return fmt.Errorf("保存地址簿失败: %w", err)
+	}
+	b.dirty = false
+	return nil
+}
+
+// validPeerAddr 判断地址是否为 host:port 格式且端口有效
+func validPeerAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// Add 记录得知的地址，lastSeen 晚于当前时间时按当前时间记录。返回此前未知的有效地址
+func (b *AddrBook) Add(addrs []NetAddress) []NetAddress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	var added []NetAddress
+	for _, addr := range addrs {
+		if !validPeerAddr(addr.Addr) {
+			continue
+		}
+		lastSeen := min(addr.LastSeen, now)
+		if known, exists := b.addrs[addr.Addr]; exists {
+			if lastSeen > known.LastSeen {
+				known.LastSeen = lastSeen
+				b.dirty = true
+			}
+			continue
+		}
+		if len(b.addrs) >= maxAddrBookSize {
+			b.evictOldest()
+		}
+		b.addrs[addr.Addr] = &KnownAddress{Addr: addr.Addr, LastSeen: lastSeen}
+		b.dirty = true
+		added = append(added, NetAddress{Addr: addr.Addr, LastSeen: lastSeen})
+	}
+	return added
+}
+
+// evictOldest 删除最久未见的地址，调用方需持有 b.mu
+func (b *AddrBook) evictOldest() {
+	var oldest *KnownAddress
+	for _, addr := range b.addrs {
+		if oldest == nil || addr.LastSeen < oldest.LastSeen {
+			oldest = addr
+		}
+	}
+	if oldest != nil {
+		delete(b.addrs, oldest.Addr)
+	}
+}
+
+// entry 返回地址的记录，不存在时创建，调用方需持有 b.mu
+func (b *AddrBook) entry(addr string) *KnownAddress {
+	known, exists := b.addrs[addr]
+	if !exists {
+		known = &KnownAddress{Addr: addr}
+		b.addrs[addr] = known
+	}
+	b.dirty = true
+	return known
+}
+
+// Good 记录与该地址的节点成功建立了会话
+func (b *AddrBook) Good(addr string) {
+	if !validPeerAddr(addr) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	known := b.entry(addr)
+	known.LastSeen = time.Now().Unix()
+	known.Failures = 0
+}
+
+// Attempt 记录即将尝试连接该地址
+func (b *AddrBook) Attempt(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if known, exists := b.addrs[addr]; exists {
+		known.LastAttempt = time.Now().Unix()
+		b.dirty = true
+	}
+}
+
+// Failed 记录连接该地址失败，之后的重试间隔随连续失败次数加倍
+func (b *AddrBook) Failed(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if known, exists := b.addrs[addr]; exists {
+		known.Failures++
+		b.dirty = true
+	}
+}
+
+// Remove 删除地址，用于连接到自己或其他网络的地址
+func (b *AddrBook) Remove(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.addrs[addr]; exists {
+		delete(b.addrs, addr)
+		b.dirty = true
+	}
+}
+
+// banHost 返回地址中的 IP 或主机名，地址没有端口时原样返回
+func banHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Misbehaving 增加地址所在 IP 的惩罚分，达到 banThreshold 时封禁 banDuration 并清零惩罚分，返回是否被封禁。
+// 记录已满时先清除未被封禁的记录
+func (b *AddrBook) Misbehaving(addr string, score int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	host := banHost(addr)
+	ban, exists := b.bans[host]
+	if !exists {
+		if len(b.bans) >= maxAddrBookSize {
+			now := time.Now().Unix()
+			for other, record := range b.bans {
+				if record.BannedUntil <= now {
+					delete(b.bans, other)
+				}
+			}
+		}
+		ban = &BanRecord{Host: host}
+		b.bans[host] = ban
+	}
+	b.dirty = true
+	ban.BanScore += score
+	if ban.BanScore < banThreshold {
+		return false
+	}
+	ban.BanScore = 0
+	ban.BannedUntil = time.Now().Add(banDuration).Unix()
+	return true
+}
+
+// IsBanned 判断地址所在的 IP 当前是否被封禁
+func (b *AddrBook) IsBanned(addr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.banned(addr, time.Now().Unix())
+}
+
+// banned 判断地址所在的 IP 在 now 时是否被封禁，调用方需持有 b.mu
+func (b *AddrBook) banned(addr string, now int64) bool {
+	ban, exists := b.bans[banHost(addr)]
+	return exists && ban.BannedUntil > now
+}
+
+// Candidates 返回可以尝试连接的地址：未被封禁、未被 skip 排除且已过重试间隔，最近见到的在前
+func (b *AddrBook) Candidates(skip func(addr string) bool) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	var candidates []*KnownAddress
+	for _, known := range b.addrs {
+		if b.banned(known.Addr, now) || known.retryAt() > now || skip(known.Addr) {
+			continue
+		}
+		candidates = append(candidates, known)
+	}
+	sortByLastSeen(candidates)
+	addrs := make([]string, len(candidates))
+	for i, known := range candidates {
+		addrs[i] = known.Addr
+	}
+	return addrs
+}
+
+// Sample 返回最多 n 个未被封禁的地址用于回复 getaddr，最近见到的在前，不包含 except
+func (b *AddrBook) Sample(n int, except string) []NetAddress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	var known []*KnownAddress
+	for _, addr := range b.addrs {
+		if !b.banned(addr.Addr, now) && addr.LastSeen > 0 && addr.Addr != except {
+			known = append(known, addr)
+		}
+	}
+	sortByLastSeen(known)
+	addrs := make([]NetAddress, 0, min(n, len(known)))
+	for _, addr := range known[:min(n, len(known))] {
+		addrs = append(addrs, NetAddress{Addr: addr.Addr, LastSeen: addr.LastSeen})
+	}
+	return addrs
+}
+
+// Stats 返回地址簿中的地址数和当前被封禁的 IP 数
+func (b *AddrBook) Stats() (total, banned int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	for _, ban := range b.bans {
+		if ban.BannedUntil > now {
+			banned++
+		}
+	}
+	return len(b.addrs), banned
+}
+
+// sortByLastSeen 按最近见到的时间从新到旧排序，时间相同时按地址排序
+func sortByLastSeen(addrs []*KnownAddress) {
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].LastSeen != addrs[j].LastSeen {
+			return addrs[i].LastSeen > addrs[j].LastSeen
+		}
+		return addrs[i].Addr < addrs[j].Addr
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddrBookAddAndSample(t *testing.T) {
+	book := NewAddrBook("")
+	now := time.Now().Unix()
+	added := book.Add([]NetAddress{
+		{"127.0.0.1:8080", now - 60},
+		{"127.0.0.1:8081", now + 3600}, // 来自未来的时间按当前时间记录
+		{"no-port", now},
+		{"127.0.0.1:0", now},
+	})
+	if len(added) != 2 || added[1].LastSeen > now+1 {
+		t.Fatalf("应只记录两个有效地址: %+v", added)
+	}
+	if again := book.Add([]NetAddress{{"127.0.0.1:8080", now}}); len(again) != 0 {
+		t.Errorf("已知地址不应再次作为新地址返回: %+v", again)
+	}
+
+	sample := book.Sample(10, "127.0.0.1:8081")
+	if len(sample) != 1 || sample[0].Addr != "127.0.0.1:8080" || sample[0].LastSeen != now {
+		t.Errorf("应返回更新了时间且不含请求方的地址: %+v", sample)
+	}
+	if got := book.Candidates(func(string) bool { return false }); !reflect.DeepEqual(got, []string{"127.0.0.1:8080", "127.0.0.1:8081"}) {
+		t.Errorf("两个地址都应可以连接，最近见到的相同时按地址排序: %v", got)
+	}
+}
+
+func TestAddrBookRetryAndBan(t *testing.T) {
+	book := NewAddrBook("")
+	addr := "127.0.0.1:8080"
+	book.Add([]NetAddress{{addr, time.Now().Unix()}})
+	none := func(string) bool { return false }
+
+	book.Attempt(addr)
+	book.Failed(addr)
+	if got := book.Candidates(none); len(got) != 0 {
+		t.Errorf("连接失败后应等待重试间隔: %v", got)
+	}
+	book.Good(addr)
+	if got := book.Candidates(none); len(got) != 1 {
+		t.Errorf("成功建立会话后应清除失败记录: %v", got)
+	}
+
+	if book.Misbehaving(addr, banThreshold-1) || book.IsBanned(addr) {
+		t.Fatal("惩罚分未达到上限时不应封禁")
+	}
+	if !book.Misbehaving(addr, 1) || !book.IsBanned(addr) {
+		t.Fatal("惩罚分达到上限时应封禁")
+	}
+	if !book.IsBanned("127.0.0.1:9000") || book.IsBanned("127.0.0.2:8080") {
+		t.Error("应封禁同一 IP 的全部端口，且只封禁该 IP")
+	}
+	if len(book.Candidates(none)) != 0 || len(book.Sample(10, "")) != 0 {
+		t.Error("被封禁的地址不应被连接或转发")
+	}
+	if total, banned := book.Stats(); total != 1 || banned != 1 {
+		t.Errorf("地址簿统计不正确: %d, %d", total, banned)
+	}
+}
+
+func TestAddrBookSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	book, err := LoadAddrBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Add([]NetAddress{{"127.0.0.1:8080", 1700000000}})
+	book.Misbehaving("127.0.0.1:8081", 30)
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadAddrBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.addrs, book.addrs) || !reflect.DeepEqual(loaded.bans, book.bans) {
+		t.Errorf("重新加载的地址簿不一致: %+v, %+v", loaded.addrs, loaded.bans)
+	}
+}
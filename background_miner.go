@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 交易池为空且不挖空块时，后台矿工检查交易池的间隔
+const backgroundMinerIdleInterval = time.Second
+
+// backgroundMiner 记录后台持续挖矿的状态，由 node.mu 保护
+type backgroundMiner struct {
+	account    string
+	allowEmpty bool // 交易池为空时是否挖只含奖励交易的空块
+	started    time.Time
+	blocks     int    // 已挖出的区块数
+	hashes     uint64 // 累计哈希次数
+	lastBlock  string // 最近挖出的区块哈希
+	stop       context.CancelFunc
+	done       chan struct{}
+}
+
+func (node *Node) handleMineStart(args []string, blockchainFile string) {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "empty") {
+		fmt.Println("用法: mine start [account] [empty]")
+		return
+	}
+
+	node.mu.Lock()
+	if node.autoMiner != nil {
+		node.mu.Unlock()
+		fmt.Printf("后台挖矿已在进行，矿工账户 %s\n", node.autoMiner.account)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	miner := &backgroundMiner{
+		account:    args[0],
+		allowEmpty: len(args) == 2,
+		started:    time.Now(),
+		stop:       cancel,
+		done:       make(chan struct{}),
+	}
+	node.autoMiner = miner
+	node.mu.Unlock()
+
+	go node.runBackgroundMiner(ctx, miner, blockchainFile)
+	fmt.Printf("后台挖矿已启动，矿工账户 %s\n", miner.account)
+}
+
+func (node *Node) handleMineStop() {
+	node.mu.Lock()
+	miner := node.autoMiner
+	node.mu.Unlock()
+	if miner == nil {
+		fmt.Println("后台挖矿未启动")
+		return
+	}
+
+	miner.stop()
+	<-miner.done
+	fmt.Printf("后台挖矿已停止，共挖出 %d 个区块\n", miner.blocks)
+}
+
+func (node *Node) handleMineStatus() {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	miner := node.autoMiner
+	if miner == nil {
+		fmt.Println("后台挖矿未启动")
+		return
+	}
+	elapsed := time.Since(miner.started)
+	fmt.Printf("后台挖矿进行中:\n")
+	fmt.Printf("  矿工账户: %s\n", miner.account)
+	fmt.Printf("  挖空块: %t\n", miner.allowEmpty)
+	fmt.Printf("  运行时间: %v\n", elapsed.Round(time.Second))
+	fmt.Printf("  已挖出区块: %d\n", miner.blocks)
+	fmt.Printf("  平均算力: %.2f H/s\n", float64(miner.hashes)/elapsed.Seconds())
+	fmt.Printf("  当前链高度: %d\n", len(node.Blockchain.Blocks)-1)
+	if miner.lastBlock != "" {
+		fmt.Printf("  最近挖出的区块: %s\n", miner.lastBlock)
+	}
+}
+
+// runBackgroundMiner 持续在最新链尾上挖矿，链尾变化时自动基于新链尾重新开始，直到 ctx 被取消
+func (node *Node) runBackgroundMiner(ctx context.Context, miner *backgroundMiner, blockchainFile string) {
+	defer func() {
+		node.mu.Lock()
+		node.autoMiner = nil
+		node.mu.Unlock()
+		close(miner.done)
+	}()
+
+	for ctx.Err() == nil {
+		node.mu.Lock()
+		poolSize := len(node.Blockchain.GetTransactionsForBlock())
+		node.mu.Unlock()
+		if poolSize == 0 && !miner.allowEmpty {
+			waitOrDone(ctx, backgroundMinerIdleInterval)
+			continue
+		}
+
+		block, stats, err := node.mineBlock(ctx, miner.account, blockchainFile)
+		node.mu.Lock()
+		miner.hashes += stats.Hashes
+		node.mu.Unlock()
+
+		switch {
+		case errors.Is(err, context.Canceled):
+			if ctx.Err() == nil {
+				fmt.Println("[挖矿] 链尾已变化，基于新链尾重新开始")
+			}
+			continue
+		case err != nil:
+			fmt.Printf("[挖矿] 挖矿失败: %v\n", err)
+			waitOrDone(ctx, backgroundMinerIdleInterval)
+			continue
+		}
+
+		node.mu.Lock()
+		miner.blocks++
+		miner.lastBlock = block.Hash
+		node.mu.Unlock()
+		fmt.Printf("[挖矿] 区块 #%d 已挖出: %d 笔交易, 难度目标 0x%08x, %s\n",
+			block.Header.Index, len(block.Transactions), block.Header.Bits, stats)
+		node.BroadcastBlock(block)
+	}
+}
+
+// waitOrDone 等待 d 或直到 ctx 被取消
+func waitOrDone(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
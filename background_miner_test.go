@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestBackgroundMinerMinesEmptyBlocks(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	chdirTemp(t)
+	node := newTestNode(bc, publicKeys)
+
+	node.handleMineStart([]string{"Alice", "empty"}, "blockchain.json")
+	node.mu.Lock()
+	miner := node.autoMiner
+	node.mu.Unlock()
+	if miner == nil || !miner.allowEmpty {
+		t.Fatal("mine start 后应有挖空块的后台矿工")
+	}
+	waitFor(t, "后台矿工挖出空块", func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return miner.blocks >= 1 && len(node.Blockchain.Blocks) > 1
+	})
+
+	node.handleMineStop()
+	select {
+	case <-miner.done:
+	default:
+		t.Fatal("mine stop 返回时后台矿工应已退出")
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.autoMiner != nil || node.miningCancel != nil {
+		t.Error("后台矿工退出后应清除挖矿状态")
+	}
+	if err := node.Blockchain.ValidateChain(publicKeys); err != nil {
+		t.Fatal(err)
+	}
+	if block := node.Blockchain.Blocks[1]; len(block.Transactions) != 1 || block.Transactions[0].Receiver != "Alice" {
+		t.Errorf("空块应只包含给 Alice 的奖励交易, 实际 %+v", block.Transactions)
+	}
+}
+
+func TestBackgroundMinerRestartsOnTipChange(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	chdirTemp(t)
+	// 下一个区块沿用链尾的难度目标，调高后测试期间不会挖出区块
+	bc.Blocks[0].Header.Bits = 0x1d00ffff
+	node := newTestNode(bc, publicKeys)
+
+	node.handleMineStart([]string{"Alice", "empty"}, "blockchain.json")
+	node.mu.Lock()
+	miner := node.autoMiner
+	node.mu.Unlock()
+	mining := func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return node.miningCancel != nil
+	}
+	waitFor(t, "后台矿工开始挖矿", mining)
+
+	// 链尾变化取消当前挖矿，后台矿工应基于新链尾重新开始而不是退出
+	node.mu.Lock()
+	node.tipChanged()
+	node.mu.Unlock()
+	waitFor(t, "后台矿工重新开始挖矿", func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return node.miningCancel != nil && miner.hashes > 0
+	})
+	node.mu.Lock()
+	if node.autoMiner != miner || miner.blocks != 0 {
+		t.Error("重新开始后应仍是同一个后台矿工且没有挖出区块")
+	}
+	node.mu.Unlock()
+
+	node.handleMineStop()
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.autoMiner != nil || node.miningCancel != nil {
+		t.Error("mine stop 后应清除挖矿状态")
+	}
+}
@@ -1,19 +1,38 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"strings"
+	"gamechain/coin"
+	"math/big"
+	"runtime"
 	"time"
 )
 
 type BlockHeader struct {
+	Version      uint32 // 区块头格式版本，0 为旧格式
 	Index        int
 	Timestamp    int64
 	PreviousHash string
 	Nonce        uint64
 	MerkleRoot   string
+	Bits         uint32 // 紧凑格式的难度目标，旧格式区块为 0
+	StateRoot    string // 执行本区块后全部账户余额和 nonce 的 Merkle 承诺，版本 2 起记录
+}
+
+// IsLegacy 判断区块头是否为旧格式
+func (h *BlockHeader) IsLegacy() bool {
+	return h.Version == legacyBlockVersion
+}
+
+// Target 返回区块哈希必须满足的难度目标
+func (h *BlockHeader) Target() *big.Int {
+	if h.IsLegacy() {
+		return legacyTarget
+	}
+	return CompactToBig(h.Bits)
 }
 
 type Block struct {
@@ -22,42 +41,68 @@ type Block struct {
 	Hash         string
 }
 
-// 创建新区块
-func NewBlock(index int, previousHash string, transactions []Transaction, miner string, reward float64, difficulty int) Block {
+// 创建新区块并完成工作量证明
+func NewBlock(index int, previousHash string, transactions []Transaction, miner string, reward coin.Amount, bits uint32) Block {
+	block := newBlockTemplate(index, previousHash, transactions, miner, reward, bits)
+	block.ProofOfWork()
+	return block
+}
+
+// newBlockTemplate 创建包含奖励交易、尚未挖矿的新区块，奖励交易的金额为 reward 加上区块内全部交易的手续费
+func newBlockTemplate(index int, previousHash string, transactions []Transaction, miner string, reward coin.Amount, bits uint32) Block {
+	for _, tx := range transactions {
+		reward += tx.Fee
+	}
+	// 奖励交易以区块高度作为 nonce，使不同区块中的奖励交易 ID 互不相同
 	rewardTx := Transaction{
+		Version:  currentTxVersion,
 		Sender:   "System",
 		Receiver: miner,
 		Amount:   reward,
+		Nonce:    uint64(index),
 	}
 	transactions = append(transactions, rewardTx)
-	block := Block{
+	return Block{
 		Header: BlockHeader{
+			Version:      currentBlockVersion,
 			Index:        index,
 			Timestamp:    time.Now().Unix(),
 			PreviousHash: previousHash,
 			MerkleRoot:   CalculateMerkleRoot(transactions),
+			Bits:         bits,
+			StateRoot:    zeroHash, // 占位，由调用方在挖矿前写入执行区块后的状态根
 		},
 		Transactions: transactions,
 	}
-	block.ProofOfWork(difficulty)
-	return block
 }
 
-// 工作量证明
-func (b *Block) ProofOfWork(difficulty int) {
-	prefix := strings.Repeat("0", difficulty)
-	for {
-		b.Hash = b.CalculateHash()
-		if strings.HasPrefix(b.Hash, prefix) {
-			break
-		}
-		b.Header.Nonce++
+// 工作量证明：使用全部 CPU 核心挖矿直到区块哈希不大于区块头中的难度目标
+func (b *Block) ProofOfWork() {
+	if _, err := b.Mine(context.Background(), runtime.GOMAXPROCS(0)); err != nil {
+		panic(fmt.Sprintf("挖矿失败: %v", err))
 	}
 }
 
-// 计算区块哈希
+// 计算区块哈希：新格式对二进制区块头做 SHA-256，旧格式沿用字符串拼接
 func (b *Block) CalculateHash() string {
-	headerData := fmt.Sprintf("%d%d%s%d%s", b.Header.Index, b.Header.Timestamp, b.Header.PreviousHash, b.Header.Nonce, b.Header.MerkleRoot)
-	hash := sha256.Sum256([]byte(headerData))
+	if b.Header.IsLegacy() {
+		hash := sha256.Sum256(b.Header.legacyHeaderData())
+		return hex.EncodeToString(hash[:])
+	}
+	data, err := b.Header.MarshalBinary()
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
 }
+
+// HasValidProofOfWork 判断区块哈希是否正确且不大于区块头记录的难度目标
+func (b *Block) HasValidProofOfWork() bool {
+	if b.Hash != b.CalculateHash() {
+		return false
+	}
+	target := b.Header.Target()
+	hash := HashToBig(b.Hash)
+	return target.Sign() > 0 && hash != nil && hash.Cmp(target) <= 0
+}
@@ -4,14 +4,18 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
-	"gamechain/account"
 	"os"
 )
 
 type Blockchain struct {
 	Blocks          []Block       // 区块列表
-	Difficulty      int           // 挖矿难度
 	TransactionPool []Transaction // 未确认的交易池
+	Params          *ChainParams  `json:"-"` // 网络共识参数
+}
+
+// withBlocks 创建一条共享共识参数、由给定区块组成的链
+func (bc *Blockchain) withBlocks(blocks []Block) *Blockchain {
+	return &Blockchain{Blocks: blocks, Params: bc.Params}
 }
 
 var blockchain *Blockchain // 全局区块链实例
@@ -29,73 +33,97 @@ func LoadBlockchain(filePath string) *Blockchain {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		fmt.Println("未找到区块链文件，创建新区块链")
-		return &Blockchain{Difficulty: 2}
+		return &Blockchain{}
 	}
 	var blockchain Blockchain
 	json.Unmarshal(data, &blockchain)
 	return &blockchain
 }
 
-func (node *Node) handleBlock(request map[string]interface{}) {
-	var block Block
-	if err := mapToStruct(request["block"], &block); err != nil {
-		fmt.Printf("区块解析失败: %v\n", err)
-		return
-	}
-	node.HandleNewBlock(block)
-}
-
-// 初始化区块链（包括加载和创世区块的创建）
-func initializeBlockchain(filePath string, difficulty int) *Blockchain {
+// 初始化区块链（包括加载和创世区块的创建），已有的链必须通过校验
+func initializeBlockchain(filePath string, params *ChainParams, publicKeys map[string]*ecdsa.PublicKey) (*Blockchain, error) {
 	blockchain := LoadBlockchain(filePath)
-	if len(blockchain.Blocks) == 0 {
-		// 创建创世区块
-		genesisBlock := NewBlock(
-			0,               // 区块编号
-			"0",             // 前一区块的哈希（创世区块无前区块）
-			[]Transaction{}, // 创世区块无交易
-			"System",        // 矿工账户（系统账户）
-			0.0,             // 奖励（创世区块无奖励）
-			difficulty,      // 挖矿难度
-		)
+	blockchain.Params = params
+	if len(blockchain.Blocks) > 0 {
+		if err := blockchain.ValidateChain(publicKeys); err != nil {
+			return nil, fmt.Errorf("区块链文件 %s 校验失败: %w", filePath, err)
+		}
+	} else {
+		genesisBlock := newGenesisBlock(params)
 		blockchain.Blocks = append(blockchain.Blocks, genesisBlock)
 		SaveBlockchain(filePath, blockchain)
 		fmt.Println("创世区块已生成并保存")
 	}
-	return blockchain
+	return blockchain, nil
 }
 
-// AddTransactionToPool 添加交易到交易池
+// AddTransactionToPool 添加交易到交易池，交易必须能在主链和交易池中已有交易之后执行
 func (bc *Blockchain) AddTransactionToPool(tx Transaction, publicKeys map[string]*ecdsa.PublicKey, filePath string) bool {
+	if !bc.Params.acceptsTxVersion(tx.Sender, tx.Version) {
+		fmt.Printf("不支持的交易版本: %d\n", tx.Version)
+		return false
+	}
 	publicKey, exists := publicKeys[tx.Sender]
 	if !exists {
 		fmt.Printf("交易发送方公钥不存在: %s\n", tx.Sender)
 		return false
 	}
-	if VerifyTransaction(&tx, publicKey) {
-		bc.TransactionPool = append(bc.TransactionPool, tx)
-		SaveBlockchain(filePath, bc)
-		fmt.Printf("交易已添加到交易池: %+v\n", tx)
-		return true
+	id := tx.ID()
+	for _, pending := range bc.TransactionPool {
+		if pending.ID() == id {
+			fmt.Printf("交易已在交易池中: %s\n", id)
+			return false
+		}
 	}
-	fmt.Printf("交易验证失败: %+v\n", tx)
-	return false
+	if expected := bc.NextNonce(tx.Sender); !tx.IsUTXO() && tx.Nonce != expected {
+		fmt.Printf("交易 nonce 不正确: 期望 %d, 实际 %d\n", expected, tx.Nonce)
+		return false
+	}
+	if !VerifyTransaction(&tx, bc.Params.ChainID, publicKey) {
+		fmt.Printf("交易验证失败: %+v\n", tx)
+		return false
+	}
+	if err := bc.pendingLedger().applyTransaction(tx, true); err != nil {
+		fmt.Printf("交易无法执行: %v\n", err)
+		return false
+	}
+	bc.TransactionPool = append(bc.TransactionPool, tx)
+	SaveBlockchain(filePath, bc)
+	fmt.Printf("交易已添加到交易池: %+v\n", tx)
+	return true
 }
 
-// ClearTransactionPool 清除已打包的交易
+// ClearTransactionPool 清除已打包的交易、nonce 已在链上被使用过的交易，以及输入已被花费的 UTXO 交易
 func (bc *Blockchain) ClearTransactionPool(transactions []Transaction) {
+	included := make(map[string]bool)
+	for _, tx := range transactions {
+		included[tx.ID()] = true
+	}
+	nonces := bc.chainNonces()
+	// UTXO 交易没有 nonce，输入已被新区块花费的交易无法再上链
+	var utxos *UTXOSet
+	if bc.Params.UTXO {
+		var err error
+		if utxos, err = bc.chainUTXOs(); err != nil {
+			fmt.Printf("重建 UTXO 集合失败: %v\n", err)
+		}
+	}
+	height := bc.Blocks[len(bc.Blocks)-1].Header.Index + 1
+
 	remaining := []Transaction{}
 	for _, tx := range bc.TransactionPool {
-		included := false
-		for _, includedTx := range transactions {
-			if tx == includedTx {
-				included = true
-				break
-			}
+		if included[tx.ID()] {
+			continue
 		}
-		if !included {
-			remaining = append(remaining, tx)
+		if tx.IsUTXO() {
+			if utxos == nil || utxos.checkInputs(&tx, height) != nil {
+				continue
+			}
+			utxos.applyTransaction(&tx, height, false)
+		} else if tx.Nonce <= nonces[tx.Sender] {
+			continue
 		}
+		remaining = append(remaining, tx)
 	}
 	bc.TransactionPool = remaining
 	fmt.Println("交易池已清理，移除已打包的交易")
@@ -110,100 +138,131 @@ func PrintBlockchain(bc *Blockchain) {
 		fmt.Printf("前一个区块哈希: %s\n", block.Header.PreviousHash)
 		fmt.Printf("区块哈希: %s\n", block.Hash)
 		fmt.Printf("Merkle 根: %s\n", block.Header.MerkleRoot)
+		if block.Header.HasStateRoot() {
+			fmt.Printf("状态根: %s\n", block.Header.StateRoot)
+		}
+		fmt.Printf("难度目标: 0x%08x\n", block.Header.Bits)
 		fmt.Printf("交易列表:\n")
 		for _, tx := range block.Transactions {
-			fmt.Printf("  %s -> %s: %.2f\n", tx.Sender, tx.Receiver, tx.Amount)
+			fmt.Printf("  %s -> %s: %s (手续费: %s, nonce: %d, ID: %s)\n", tx.Sender, tx.Receiver, tx.Amount, tx.Fee, tx.Nonce, tx.ID())
+			printInputsOutputs(&tx, "    ")
 		}
 		fmt.Println("------------------------------")
 	}
 }
 
-func (bc *Blockchain) GetTransactionsForBlock() []Transaction {
-	return bc.TransactionPool
+func (node *Node) printBlockchain() {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	PrintBlockchain(node.Blockchain)
 }
 
-func (bc *Blockchain) AddBlock(transactions []Transaction, miner string, publicKeys map[string]*ecdsa.PublicKey, filePath string) {
-	validTransactions := []Transaction{}
-	for _, tx := range transactions {
-		if publicKey, exists := publicKeys[tx.Sender]; exists && VerifyTransaction(&tx, publicKey) {
-			validTransactions = append(validTransactions, tx)
-		} else {
-			fmt.Printf("交易验证失败: %+v\n", tx)
+// FindBlock 按哈希查找主链上的区块，返回其在链中的位置，不存在时返回 -1
+func (bc *Blockchain) FindBlock(hash string) int {
+	for i := len(bc.Blocks) - 1; i >= 0; i-- {
+		if bc.Blocks[i].Hash == hash {
+			return i
 		}
 	}
-
-	lastBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := NewBlock(
-		lastBlock.Header.Index+1, // 区块索引
-		lastBlock.Hash,           // 前一区块哈希
-		validTransactions,        // 验证后的交易
-		miner,                    // 矿工账户
-		50.0,                     // 挖矿奖励
-		bc.Difficulty,            // 挖矿难度
-	)
-	bc.Blocks = append(bc.Blocks, newBlock)
-	SaveBlockchain(filePath, bc)
-	fmt.Println("新区块已生成")
+	return -1
 }
 
-func (bc *Blockchain) GetBalance(account string, accounts []account.Account) (float64, bool) {
-	exists := false
-	balance := 0.0
-
-	// 遍历区块链获取交易记录
-	for _, block := range bc.Blocks {
-		for _, tx := range block.Transactions {
-			if tx.Sender == account || tx.Receiver == account {
-				exists = true
-			}
-			if tx.Sender == account {
-				balance -= tx.Amount
-			}
-			if tx.Receiver == account {
-				balance += tx.Amount
+// FindTransaction 按 ID 查找交易，先查主链再查交易池。
+// 返回交易所在区块在链中的位置，交易仍在交易池中时为 -1
+func (bc *Blockchain) FindTransaction(id string) (Transaction, int, bool) {
+	for i := len(bc.Blocks) - 1; i >= 0; i-- {
+		for _, tx := range bc.Blocks[i].Transactions {
+			if tx.ID() == id {
+				return tx, i, true
 			}
 		}
 	}
-
-	// 检查账户是否在 accounts.json 中存在
-	if !exists {
-		for _, acc := range accounts {
-			if acc.Name == account {
-				exists = true
-				balance = 100.0 // 返回初始余额
-				break
-			}
+	for _, tx := range bc.TransactionPool {
+		if tx.ID() == id {
+			return tx, -1, true
 		}
 	}
+	return Transaction{}, -1, false
+}
 
-	return balance, exists
+// GetTransactionsForBlock 返回按手续费率从高到低排列的待打包交易，同一发送方的交易保持 nonce 顺序
+func (bc *Blockchain) GetTransactionsForBlock() []Transaction {
+	return feeOrderedTransactions(bc.TransactionPool)
 }
 
-// ValidateBalance 根据区块链的记录验证账户余额
-func (bc *Blockchain) ValidateBalance(account string) float64 {
-	balance := 0.0
+// PrepareBlock 按顺序验证待打包的交易并在链尾之后生成尚未挖矿的新区块，
+// 交易数和字节数达到区块上限后剩余交易留在交易池中
+func (bc *Blockchain) PrepareBlock(transactions []Transaction, miner string, publicKeys map[string]*ecdsa.PublicKey) Block {
+	lastBlock := bc.Blocks[len(bc.Blocks)-1]
+	index := lastBlock.Header.Index + 1
 
-	// 遍历区块链计算余额
-	for _, block := range bc.Blocks {
-		for _, tx := range block.Transactions {
-			if tx.Sender == account {
-				balance -= tx.Amount
-			}
-			if tx.Receiver == account {
-				balance += tx.Amount
-			}
-		}
-	}
+	// 为奖励交易预留位置，奖励金额不影响交易的字节数
+	rewardTx := Transaction{Version: currentTxVersion, Sender: "System", Receiver: miner, Nonce: uint64(index)}
+	size := rewardTx.Size()
 
-	// 遍历交易池计算余额（仅处理未确认交易）
-	for _, tx := range bc.TransactionPool {
-		if tx.Sender == account {
-			balance -= tx.Amount
+	l := bc.chainLedger()
+	skipped := make(map[string]bool) // 有交易未能打包的发送方，其后续交易的 nonce 已不连续
+	validTransactions := []Transaction{}
+	for _, tx := range transactions {
+		if len(validTransactions)+1 >= maxBlockTxs {
+			break
+		}
+		if skipped[tx.Sender] {
+			continue
+		}
+		if size+tx.Size() > maxBlockSize {
+			skipped[tx.Sender] = true
+			continue
+		}
+		publicKey, exists := publicKeys[tx.Sender]
+		if !exists || !VerifyTransaction(&tx, bc.Params.ChainID, publicKey) {
+			fmt.Printf("交易验证失败: %+v\n", tx)
+			skipped[tx.Sender] = true
+			continue
 		}
-		if tx.Receiver == account {
-			balance += tx.Amount
+		if err := l.applyTransaction(tx, true); err != nil {
+			fmt.Printf("交易暂不能打包: %v\n", err)
+			skipped[tx.Sender] = true
+			continue
 		}
+		size += tx.Size()
+		validTransactions = append(validTransactions, tx)
+	}
+
+	return bc.newBlockOnTip(validTransactions, miner, publicKeys)
+}
+
+// newBlockOnTip 在链尾之后创建包含给定交易、尚未挖矿的新区块，
+// 区块头中的状态根为在主链账本上执行该区块后的结果
+func (bc *Blockchain) newBlockOnTip(transactions []Transaction, miner string, publicKeys map[string]*ecdsa.PublicKey) Block {
+	lastBlock := bc.Blocks[len(bc.Blocks)-1]
+	index := lastBlock.Header.Index + 1
+	block := newBlockTemplate(
+		index,                    // 区块索引
+		lastBlock.Hash,           // 前一区块哈希
+		transactions,             // 验证后的交易
+		miner,                    // 矿工账户
+		bc.Params.Subsidy(index), // 出块奖励
+		bc.NextBits(),            // 难度目标
+	)
+	// 本地时间不晚于此前区块的中位时间时（例如连续快速出块），取中位时间之后的一秒
+	if medianTime := bc.medianTimePast(index); block.Header.Timestamp <= medianTime {
+		block.Header.Timestamp = medianTime + 1
 	}
+	l := bc.chainLedger()
+	l.applyBlock(&block)
+	block.Header.StateRoot = l.stateRoot()
+	return block
+}
 
-	return balance
+// AddBlock 将挖出的区块追加到链尾并保存，链尾已变化时返回错误
+func (bc *Blockchain) AddBlock(block Block, filePath string) error {
+	lastBlock := bc.Blocks[len(bc.Blocks)-1]
+	if block.Header.PreviousHash != lastBlock.Hash {
+		return fmt.Errorf("链尾已变化: 区块基于 %s, 当前链尾 %s", block.Header.PreviousHash, lastBlock.Hash)
+	}
+	bc.Blocks = append(bc.Blocks, block)
+	SaveBlockchain(filePath, bc)
+	fmt.Println("新区块已生成")
+	return nil
 }
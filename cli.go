@@ -23,15 +23,22 @@ func (node *Node) RunInteractive(
 		"help": node.showHelp,
 		"mine": func(args []string) { node.handleMine(args, blockchainFile) },
 		"tx": func(args []string) {
-			node.handleTransactionCommand(args, privateKeys, transactionPoolFile, balanceManager)
+			node.handleTransactionCommand(args, privateKeys, transactionPoolFile)
 		},
-		"sync":    func(args []string) { node.SyncBlockchain() },
+		"tx_batch": func(args []string) {
+			node.handleBatchTransactionCommand(args, privateKeys, transactionPoolFile)
+		},
+		"sync":    func(args []string) { node.handleSyncCommand(args) },
+		"peers":   func(args []string) { node.handlePeersCommand(args) },
 		"balance": func(args []string) { node.handleBalanceCommand(args, balanceManager) },
+		"nonce":   func(args []string) { node.handleNonceCommand(args) },
+		"utxos":   func(args []string) { node.handleUTXOsCommand(args) },
+		"tx_info": func(args []string) { node.handleTxInfoCommand(args) },
 		"create_account": func(args []string) {
 			node.handleCreateAccountCommand(args, accounts, privateKeys, accountsFile, encryptionKey, balanceManager)
 		},
 		"list_accounts":  func(args []string) { node.listAccounts(accounts) },
-		"print":          func(args []string) { PrintBlockchain(node.Blockchain) },
+		"print":          func(args []string) { node.printBlockchain() },
 		"verify_balance": func(args []string) { node.handleVerifyBalanceCommand(args, balanceManager) },
 		"exit":           func(args []string) { node.exitNode(balanceManager) },
 	}
@@ -59,13 +66,21 @@ func (node *Node) RunInteractive(
 
 func (node *Node) showHelp(args []string) {
 	fmt.Println("可用指令：")
-	fmt.Println("  mine - 挖矿并生成新区块")
-	fmt.Println("  tx [sender] [receiver] [amount] - 创建并广播交易")
-	// fmt.Println("  sync - 从其他节点同步区块链")
+	fmt.Println("  mine [miner] - 挖矿并生成新区块")
+	fmt.Println("  mine start [account] [empty] - 启动后台持续挖矿，加 empty 时交易池为空也挖空块")
+	fmt.Println("  mine stop - 停止后台挖矿")
+	fmt.Println("  mine status - 查看后台挖矿状态")
+	fmt.Println("  tx [sender] [receiver] [amount] [fee] - 创建并广播交易，手续费可选，默认为 0")
+	fmt.Println("  tx_batch [sender] [file.csv] [fee] - 读取每行为 收款方,金额 的 CSV 文件，用一笔交易向全部收款方付款")
+	fmt.Println("  tx_info [id] - 按交易 ID 查询交易及其打包状态")
+	fmt.Println("  sync [status] - 从其他节点同步区块链，加 status 查看同步进度")
+	fmt.Println("  peers - 列出已连接的节点及其高度和延迟")
 	fmt.Println("  balance [account] - 查询账户余额")
+	fmt.Println("  nonce [account] - 查询账户下一笔交易应使用的 nonce")
+	fmt.Println("  utxos [account] - UTXO 模式下列出账户的未花费输出")
 	fmt.Println("  create_account [name] - 创建新账户")
 	fmt.Println("  list_accounts - 列出所有账户")
 	fmt.Println("  print - 打印区块链状态")
-	fmt.Println("  verify_balance [account] - 验证账户余额是否与区块链记录一致")
+	fmt.Println("  verify_balance - 比较余额缓存与链尾区块头中的状态根，不一致时从区块链重建")
 	fmt.Println("  exit - 退出程序")
 }
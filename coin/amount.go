@@ -0,0 +1,141 @@
+package coin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount 以最小单位计数的金额，1 个币等于 10^8 个最小单位
+type Amount int64
+
+const (
+	// Decimals 金额小数部分的位数
+	Decimals = 8
+	// Unit 最小单位
+	Unit Amount = 1
+	// Coin 一个币对应的最小单位数
+	Coin Amount = 100_000_000
+)
+
+// ErrOverflow 表示金额运算超出 int64 范围
+var ErrOverflow = errors.New("金额溢出")
+
+// Add 返回 a+b，溢出时返回 ErrOverflow
+func (a Amount) Add(b Amount) (Amount, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, ErrOverflow
+	}
+	return a + b, nil
+}
+
+// Sub 返回 a-b，溢出时返回 ErrOverflow
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+		return 0, ErrOverflow
+	}
+	return a - b, nil
+}
+
+// Float64 返回以币为单位的浮点数值，仅用于兼容旧格式数据和展示
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Coin)
+}
+
+// String 以币为单位格式化金额，去掉小数部分末尾的 0，例如 "12.5"、"100"
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a)
+	if a < 0 {
+		sign = "-"
+		units = -units
+	}
+	whole, frac := units/uint64(Coin), units%uint64(Coin)
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	fracText := strings.TrimRight(fmt.Sprintf("%0*d", Decimals, frac), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, fracText)
+}
+
+// Parse 解析以币为单位的十进制金额，例如 "12.5"，小数部分最多 8 位
+func Parse(s string) (Amount, error) {
+	return parseDecimal(s, false)
+}
+
+// parseDecimal 解析十进制金额。round 为 true 时超过 8 位的小数按四舍五入处理，
+// 用于读取以 float64 保存的旧数据（例如 382.73999999999995）
+func parseDecimal(s string, round bool) (Amount, error) {
+	text := s
+	negative := false
+	if strings.HasPrefix(text, "-") {
+		negative = true
+		text = text[1:]
+	}
+	whole, frac, hasPoint := strings.Cut(text, ".")
+	if whole == "" || (hasPoint && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("无效金额: %q", s)
+	}
+
+	roundUp := false
+	if len(frac) > Decimals {
+		if !round {
+			return 0, fmt.Errorf("金额 %q 的小数位数超过 %d 位", s, Decimals)
+		}
+		roundUp = frac[Decimals] >= '5'
+		frac = frac[:Decimals]
+	}
+	frac += strings.Repeat("0", Decimals-len(frac))
+
+	wholeUnits, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || wholeUnits > math.MaxInt64/int64(Coin) {
+		return 0, fmt.Errorf("金额 %q 超出范围", s)
+	}
+	fracUnits, _ := strconv.ParseInt(frac, 10, 64)
+	amount, err := (Amount(wholeUnits) * Coin).Add(Amount(fracUnits))
+	if err == nil && roundUp {
+		amount, err = amount.Add(Unit)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("金额 %q 超出范围", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON 将金额编码为以币为单位的 JSON 数字，例如 12.5
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON 解析以币为单位的 JSON 数字或字符串。
+// 兼容以 float64 保存的旧数据：超过 8 位的小数四舍五入，指数形式按浮点数解析
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if strings.ContainsAny(text, "eE") {
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("无效金额: %s", data)
+		}
+		text = strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	amount, err := parseDecimal(text, true)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
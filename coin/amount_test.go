@@ -0,0 +1,87 @@
+package coin
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseAndString(t *testing.T) {
+	cases := []struct {
+		text   string
+		amount Amount
+		str    string
+	}{
+		{"50", 50 * Coin, "50"},
+		{"12.5", 12*Coin + 50_000_000, "12.5"},
+		{"0.00000001", Unit, "0.00000001"},
+		{"382.74", 382*Coin + 74_000_000, "382.74"},
+		{"-1.10", -(Coin + 10_000_000), "-1.1"},
+		{"007.000", 7 * Coin, "7"},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.text)
+		if err != nil {
+			t.Errorf("Parse(%q) 失败: %v", c.text, err)
+			continue
+		}
+		if got != c.amount {
+			t.Errorf("Parse(%q) = %d, 期望 %d", c.text, got, c.amount)
+		}
+		if got.String() != c.str {
+			t.Errorf("%d 格式化为 %q, 期望 %q", got, got.String(), c.str)
+		}
+	}
+
+	for _, text := range []string{"", ".5", "5.", "1e3", "abc", "1.000000001", "92233720369", "+1", "1.2.3"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) 应返回错误", text)
+		}
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	if _, err := Amount(math.MaxInt64).Add(Unit); !errors.Is(err, ErrOverflow) {
+		t.Error("上溢应返回 ErrOverflow")
+	}
+	if _, err := Amount(math.MinInt64).Sub(Unit); !errors.Is(err, ErrOverflow) {
+		t.Error("下溢应返回 ErrOverflow")
+	}
+	if _, err := Amount(math.MinInt64).Add(-Unit); !errors.Is(err, ErrOverflow) {
+		t.Error("负数相加下溢应返回 ErrOverflow")
+	}
+	if sum, err := (10 * Coin).Add(5 * Coin); err != nil || sum != 15*Coin {
+		t.Errorf("10 + 5 = %v (%v)", sum, err)
+	}
+	if diff, err := (10 * Coin).Sub(15 * Coin); err != nil || diff != -5*Coin {
+		t.Errorf("10 - 15 = %v (%v)", diff, err)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	var balances map[string]Amount
+	legacy := `{"Alice": 382.73999999999995, "Charls": 281.18000000000006, "Bob": "295.51", "Dave": 1e2}`
+	if err := json.Unmarshal([]byte(legacy), &balances); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"Alice": "382.74", "Charls": "281.18", "Bob": "295.51", "Dave": "100"}
+	for name, str := range expected {
+		if balances[name].String() != str {
+			t.Errorf("%s 的余额为 %s, 期望 %s", name, balances[name], str)
+		}
+	}
+
+	data, err := json.Marshal(map[string]Amount{"Alice": 12*Coin + 34_000_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"Alice":12.34}` {
+		t.Errorf("编码结果为 %s", data)
+	}
+
+	var bad Amount
+	if err := json.Unmarshal([]byte(`"abc"`), &bad); err == nil {
+		t.Error("无效金额应返回错误")
+	}
+}
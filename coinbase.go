@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"gamechain/coin"
+)
+
+// maxHalvings 之后的奖励右移后恒为 0
+const maxHalvings = 63
+
+// Subsidy 返回高度为 height 的区块的出块奖励（不含手续费）。
+// 创世区块没有奖励；之后每 HalvingInterval 个区块奖励减半，累计发行量达到 MaxSupply 后不再有奖励
+func (p *ChainParams) Subsidy(height int) coin.Amount {
+	if height <= 0 {
+		return 0
+	}
+	halvings := (height - 1) / p.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return min(p.InitialSubsidy>>halvings, p.MaxSupply-p.issuedBefore(height))
+}
+
+// issuedBefore 返回高度 1 到 height-1 的区块累计发行的奖励，不超过 MaxSupply
+func (p *ChainParams) issuedBefore(height int) coin.Amount {
+	var issued coin.Amount
+	for halvings := 0; halvings < maxHalvings; halvings++ {
+		start := halvings*p.HalvingInterval + 1
+		subsidy := p.InitialSubsidy >> halvings
+		if start >= height || subsidy == 0 {
+			break
+		}
+		count := coin.Amount(min(height-start, p.HalvingInterval))
+		if count > (p.MaxSupply-issued)/subsidy {
+			return p.MaxSupply
+		}
+		issued += count * subsidy
+	}
+	return min(issued, p.MaxSupply)
+}
+
+// validateCoinbase 校验新格式区块的奖励交易：区块有且只有一笔奖励交易且位于最后，
+// nonce 为区块高度，金额等于该高度的出块奖励加上区块内全部交易的手续费
+func validateCoinbase(block *Block, params *ChainParams) error {
+	count := len(block.Transactions)
+	if count == 0 || block.Transactions[count-1].Sender != "System" {
+		return fmt.Errorf("区块的最后一笔交易必须是奖励交易")
+	}
+	var fees coin.Amount
+	for _, tx := range block.Transactions[:count-1] {
+		if tx.Sender == "System" {
+			return fmt.Errorf("区块只能包含一笔奖励交易")
+		}
+		var err error
+		if fees, err = fees.Add(tx.Fee); err != nil {
+			return fmt.Errorf("区块手续费总额溢出: %w", err)
+		}
+	}
+
+	coinbase := block.Transactions[count-1]
+	height := block.Header.Index
+	if coinbase.Nonce != uint64(height) {
+		return fmt.Errorf("奖励交易的 nonce 应为区块高度 %d, 实际 %d", height, coinbase.Nonce)
+	}
+	expected, err := params.Subsidy(height).Add(fees)
+	if err != nil {
+		return fmt.Errorf("区块奖励溢出: %w", err)
+	}
+	if coinbase.Amount != expected {
+		return fmt.Errorf("奖励金额不正确: 期望 %s（出块奖励 %s + 手续费 %s）, 实际 %s",
+			expected, params.Subsidy(height), fees, coinbase.Amount)
+	}
+	return nil
+}
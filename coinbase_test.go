@@ -0,0 +1,91 @@
+package main
+
+import (
+	"gamechain/coin"
+	"testing"
+)
+
+func TestSubsidyHalvingAndMaxSupply(t *testing.T) {
+	params := &ChainParams{InitialSubsidy: 50 * coin.Coin, HalvingInterval: 2, MaxSupply: 130 * coin.Coin}
+	// 累计发行: 50, 100, 125，第 4 个区块只能再发行 5 即达到上限
+	want := []coin.Amount{0, 50 * coin.Coin, 50 * coin.Coin, 25 * coin.Coin, 5 * coin.Coin, 0, 0}
+	for height, expected := range want {
+		if got := params.Subsidy(height); got != expected {
+			t.Errorf("高度 %d 的出块奖励为 %s, 期望 %s", height, got, expected)
+		}
+	}
+
+	unbounded := &ChainParams{InitialSubsidy: 50 * coin.Coin, HalvingInterval: 1, MaxSupply: 1_000_000 * coin.Coin}
+	if got := unbounded.Subsidy(64); got != 0 {
+		t.Errorf("63 次减半之后出块奖励应为 0, 实际 %s", got)
+	}
+	if got := unbounded.Subsidy(3); got != 12*coin.Coin+coin.Coin/2 {
+		t.Errorf("两次减半后出块奖励应为 12.5, 实际 %s", got)
+	}
+}
+
+func TestValidateNextBlockRejectsBadCoinbase(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(txs []Transaction) []Transaction
+	}{
+		{"奖励金额超过出块奖励加手续费", func(txs []Transaction) []Transaction {
+			txs[len(txs)-1].Amount++
+			return txs
+		}},
+		{"缺少奖励交易", func(txs []Transaction) []Transaction {
+			return txs[:len(txs)-1]
+		}},
+		{"两笔奖励交易", func(txs []Transaction) []Transaction {
+			return append([]Transaction{txs[len(txs)-1]}, txs...)
+		}},
+		{"奖励交易不在最后", func(txs []Transaction) []Transaction {
+			return append([]Transaction{txs[len(txs)-1]}, txs[:len(txs)-1]...)
+		}},
+		{"奖励交易 nonce 不是区块高度", func(txs []Transaction) []Transaction {
+			txs[len(txs)-1].Nonce = 7
+			return txs
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, privateKeys, publicKeys := newTestChain(t)
+			tx := NewTransaction(testParams.ChainID, "Alice", "Bob", coin.Coin, coin.Coin, 1, privateKeys["Alice"])
+			block := bc.PrepareBlock([]Transaction{tx}, "Bob", publicKeys)
+			block.Transactions = tt.tamper(block.Transactions)
+			block.Header.MerkleRoot = CalculateMerkleRoot(block.Transactions)
+			block.ProofOfWork()
+			if err := bc.validateNextBlock(&block, publicKeys); err == nil {
+				t.Error("奖励交易不合法的区块应被拒绝")
+			}
+		})
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	params := *testParams
+	params.CoinbaseMaturity = 3
+	bc.Params = &params
+
+	// Bob 在高度 1 获得 50 的奖励，高度 4 之前只能花费初始余额 100
+	mineTestBlock(bc, nil, "Bob", publicKeys)
+	spend := NewTransaction(params.ChainID, "Bob", "Alice", 120*coin.Coin, 0, 1, privateKeys["Bob"])
+	for height := 2; height <= 4; height++ {
+		err := bc.chainLedger().applyTransaction(spend, true)
+		if height < 4 && err == nil {
+			t.Errorf("高度 %d 时未成熟的奖励不应被花费", height)
+		}
+		if height == 4 && err != nil {
+			t.Errorf("高度 4 时奖励已成熟, 交易应有效: %v", err)
+		}
+		if height < 4 {
+			mineTestBlock(bc, nil, "Alice", publicKeys)
+		}
+	}
+
+	mineTestBlock(bc, []Transaction{spend}, "Alice", publicKeys)
+	if err := bc.ValidateChain(publicKeys); err != nil {
+		t.Fatalf("花费已成熟奖励的链应通过校验: %v", err)
+	}
+}
@@ -1,9 +1,16 @@
 package main
 
+import "gamechain/coin"
+
 const (
 	accountsFile        = "accounts.json"
 	blockchainFile      = "blockchain.json"
 	transactionPoolFile = "transaction_pool.json"
 	encryptionKey       = "my_secure_password"
 	balancesFile        = "balances.json"
+	peersFile           = "peers.json"    // 节点地址簿
+	initialBalance      = 100 * coin.Coin // 旧格式创世区块的链中每个已知账户的初始余额
+	maxBlockTxs         = 1000            // 每个区块最多包含的交易数（含奖励交易）
+	maxBlockSize        = 256 * 1024      // 区块内全部交易的字节数上限（含奖励交易）
+	maxTxOutputs        = 256             // 每笔多输出交易最多包含的输出数
 )
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// 节点发现参数
+const (
+	defaultMaxOutbound = 8  // 默认的目标出站会话数
+	maxRelayAddrs      = 10 // 收到的 addr 不超过该数量时，将其中新得知的地址转发给其他节点
+)
+
+// relay 将消息发送给除 except 外的全部会话
+func (node *Node) relay(msg Message, except *Peer) {
+	for _, p := range node.Peers() {
+		if p != except {
+			p.Send(msg)
+		}
+	}
+}
+
+// banAddr 返回地址簿中记录节点惩罚分和封禁的地址，即连接的对端地址，地址簿按其中的 IP 封禁。
+// 对方声明的监听地址未经验证，不用于封禁
+func (p *Peer) banAddr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// misbehaving 增加节点的惩罚分，达到上限时封禁并断开会话
+func (node *Node) misbehaving(p *Peer, score int, reason string) {
+	if node.AddrBook.Misbehaving(p.banAddr(), score) {
+		fmt.Printf("节点 %s 的惩罚分达到上限，封禁 %s: %s\n", p.Addr, banDuration, reason)
+		p.close()
+	}
+}
+
+// verifyListenAddr 回拨入站会话声明的监听地址，握手得到的节点标识与入站会话相同时，
+// 才把该地址记入地址簿并转发给其他节点，使新节点被整个网络得知。已有与该地址的同一节点的出站会话时不再回拨
+func (node *Node) verifyListenAddr(p *Peer) {
+	addr := p.ListenAddr
+	if !validPeerAddr(addr) || addr == node.Address || node.AddrBook.IsBanned(addr) {
+		return
+	}
+	if outbound := node.peer(addr); outbound == nil || !sameNode(p, outbound) {
+		if !node.dialBack(addr, p.nonce) {
+			fmt.Printf("节点 %s 声明的监听地址 %s 无法回拨，不转发\n", p.Addr, addr)
+			return
+		}
+	}
+	node.AddrBook.Good(addr)
+	node.relay(AddrMessage{Addrs: []NetAddress{{Addr: addr, LastSeen: time.Now().Unix()}}}, p)
+}
+
+// dialBack 连接地址并完成握手后断开，返回对方是否为标识为 nonce 的节点
+func (node *Node) dialBack(addr string, nonce uint64) bool {
+	conn, err := connectWithTimeout(addr, 5*time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	probe := newPeer(node, conn, addr, false)
+	return probe.handshake() == nil && probe.nonce == nonce
+}
+
+// handleAddr 记录对方发来的地址。少量地址通常是刚加入网络的节点，其中新得知的地址继续转发，
+// 每个节点只在第一次得知时转发，因此转发会在整个网络传播一次后停止
+func (node *Node) handleAddr(p *Peer, msg AddrMessage) {
+	if len(msg.Addrs) > maxAddrPerMessage {
+		node.misbehaving(p, 20, fmt.Sprintf("addr 消息包含 %d 个地址", len(msg.Addrs)))
+		return
+	}
+	added := node.AddrBook.Add(msg.Addrs)
+	if len(added) == 0 {
+		return
+	}
+	fmt.Printf("从节点 %s 得知 %d 个新地址\n", p.Addr, len(added))
+	if len(msg.Addrs) <= maxRelayAddrs {
+		node.relay(AddrMessage{Addrs: added}, p)
+	}
+}
+
+// outboundCount 返回当前的出站会话数
+func (node *Node) outboundCount() int {
+	count := 0
+	for _, p := range node.Peers() {
+		if !p.Inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// fillOutbound 出站会话不足 MaxOutbound 个时，从地址簿中按最近见到的顺序挑选节点连接；
+// 地址簿中没有可以尝试的地址时连接种子节点，握手后向其请求地址
+func (node *Node) fillOutbound() {
+	need := node.MaxOutbound - node.outboundCount()
+	if need <= 0 {
+		return
+	}
+	skip := func(addr string) bool { return addr == node.Address || node.peer(addr) != nil }
+	candidates := node.AddrBook.Candidates(skip)
+	if len(candidates) == 0 {
+		for _, seed := range node.SeedNodes {
+			if !skip(seed) && !node.AddrBook.IsBanned(seed) {
+				candidates = append(candidates, seed)
+			}
+		}
+	}
+	for _, addr := range candidates {
+		if need == 0 {
+			return
+		}
+		node.AddrBook.Attempt(addr)
+		if err := node.connectPeer(addr); err != nil {
+			node.AddrBook.Failed(addr)
+			continue
+		}
+		need--
+	}
+}
+
+// maintainPeers 定期维护会话：保持与 --peers 中节点的会话，补足出站会话，重新分配超时的区块请求，地址簿有修改时保存。
+// --peers 中的节点连接失败只在状态变化时提示
+func (node *Node) maintainPeers() {
+	failed := make(map[string]bool)
+	for {
+		for _, addr := range node.PeerNodes {
+			if node.peer(addr) != nil {
+				continue
+			}
+			if err := node.connectPeer(addr); err != nil {
+				if !failed[addr] {
+					fmt.Printf("无法连接到节点 %s: %v\n", addr, err)
+				}
+				failed[addr] = true
+				continue
+			}
+			failed[addr] = false
+		}
+		node.fillOutbound()
+		node.retrySync()
+		if err := node.AddrBook.Save(); err != nil {
+			fmt.Println(err)
+		}
+		time.Sleep(reconnectInterval)
+	}
+}
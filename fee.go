@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+)
+
+// hasHigherFeeRate 判断交易 a 的手续费率（每字节手续费）是否高于 b。
+// 通过交叉相乘比较 a.Fee/a.Size 与 b.Fee/b.Size，使用 128 位乘积避免溢出
+func hasHigherFeeRate(a, b *Transaction) bool {
+	aHi, aLo := bits.Mul64(uint64(a.Fee), uint64(b.Size()))
+	bHi, bLo := bits.Mul64(uint64(b.Fee), uint64(a.Size()))
+	if aHi != bHi {
+		return aHi > bHi
+	}
+	return aLo > bLo
+}
+
+// pendingTx 记录交易及其进入交易池的先后顺序
+type pendingTx struct {
+	tx      Transaction
+	arrival int
+}
+
+// senderQueue 同一发送方按 nonce 排好序的待打包交易
+type senderQueue []pendingTx
+
+// feeHeap 以各发送方队首交易的手续费率排序，手续费率相同时先进入交易池的优先
+type feeHeap []senderQueue
+
+func (h feeHeap) Len() int { return len(h) }
+func (h feeHeap) Less(i, j int) bool {
+	a, b := &h[i][0], &h[j][0]
+	if hasHigherFeeRate(&a.tx, &b.tx) {
+		return true
+	}
+	if hasHigherFeeRate(&b.tx, &a.tx) {
+		return false
+	}
+	return a.arrival < b.arrival
+}
+func (h feeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *feeHeap) Push(x any)   { *h = append(*h, x.(senderQueue)) }
+func (h *feeHeap) Pop() any {
+	old := *h
+	queue := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return queue
+}
+
+// feeOrderedTransactions 按手续费率从高到低排列交易。
+// 同一发送方的交易必须按 nonce 顺序上链，因此每次只比较各发送方 nonce 最小的那笔交易
+func feeOrderedTransactions(pool []Transaction) []Transaction {
+	bySender := make(map[string]senderQueue)
+	for i, tx := range pool {
+		bySender[tx.Sender] = append(bySender[tx.Sender], pendingTx{tx: tx, arrival: i})
+	}
+
+	h := make(feeHeap, 0, len(bySender))
+	for _, queue := range bySender {
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].tx.Nonce < queue[j].tx.Nonce })
+		h = append(h, queue)
+	}
+	heap.Init(&h)
+
+	ordered := make([]Transaction, 0, len(pool))
+	for h.Len() > 0 {
+		queue := h[0]
+		ordered = append(ordered, queue[0].tx)
+		if len(queue) > 1 {
+			h[0] = queue[1:]
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"gamechain/coin"
+	"testing"
+)
+
+func TestFeeOrderedTransactionsKeepsNonceOrder(t *testing.T) {
+	tx := func(sender string, fee coin.Amount, nonce uint64) Transaction {
+		return Transaction{Version: currentTxVersion, Sender: sender, Receiver: "Bob", Amount: coin.Coin, Fee: fee, Nonce: nonce}
+	}
+	// Dave 与 Erin 的交易大小和手续费相同，按进入交易池的顺序排列；
+	// Alice 的第二笔交易手续费最高，但必须排在她手续费最低的第一笔交易之后
+	pool := []Transaction{
+		tx("Alice", 1, 1),
+		tx("Alice", 900, 2),
+		tx("Carol", 0, 1),
+		tx("Dave", 50, 1),
+		tx("Erin", 50, 1),
+	}
+	got := feeOrderedTransactions(pool)
+
+	want := []string{"Dave/1", "Erin/1", "Alice/1", "Alice/2", "Carol/1"}
+	if len(got) != len(want) {
+		t.Fatalf("期望 %d 笔交易, 实际 %d 笔", len(want), len(got))
+	}
+	for i, tx := range got {
+		if id := fmt.Sprintf("%s/%d", tx.Sender, tx.Nonce); id != want[i] {
+			t.Errorf("第 %d 笔交易为 %s, 期望 %s", i, id, want[i])
+		}
+	}
+}
+
+func TestPrepareBlockCreditsFeesAndLimitsSize(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	for nonce := uint64(1); nonce <= maxBlockTxs+5; nonce++ {
+		bc.TransactionPool = append(bc.TransactionPool,
+			NewTransaction(testParams.ChainID, "Alice", "Bob", coin.Unit, 2*coin.Unit, nonce, privateKeys["Alice"]))
+	}
+
+	block := bc.PrepareBlock(bc.GetTransactionsForBlock(), "Miner", publicKeys)
+	if len(block.Transactions) != maxBlockTxs {
+		t.Fatalf("区块应包含 %d 笔交易, 实际 %d 笔", maxBlockTxs, len(block.Transactions))
+	}
+	reward := block.Transactions[len(block.Transactions)-1]
+	if want := testParams.Subsidy(1) + 2*coin.Unit*(maxBlockTxs-1); reward.Receiver != "Miner" || reward.Amount != want {
+		t.Errorf("矿工奖励应为 %s, 实际 %s -> %s", want, reward.Amount, reward.Receiver)
+	}
+
+	block.ProofOfWork()
+	if err := bc.validateNextBlock(&block, publicKeys); err != nil {
+		t.Fatalf("打包的区块应通过校验: %v", err)
+	}
+	l := bc.withBlocks(append(bc.Blocks, block)).chainLedger()
+	if want := testParams.GenesisAlloc["Alice"] - 3*coin.Unit*(maxBlockTxs-1); l.balances["Alice"] != want {
+		t.Errorf("Alice 余额应为 %s, 实际 %s", want, l.balances["Alice"])
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	maxFutureTxsPerSender = 16   // 每个发送方最多暂存的交易数，也是 nonce 最多超前的数量
+	maxFutureTxs          = 1000 // 暂存池最多保存的交易数
+)
+
+// FutureTxPool 暂存 nonce 超前于发送方下一个 nonce 的交易，按发送方和 nonce 索引。
+// 交易经多跳转发时可能乱序到达，缺失的交易到达后再把暂存的交易依次加入交易池
+type FutureTxPool struct {
+	mu       sync.Mutex
+	bySender map[string]map[uint64]Transaction
+	size     int
+}
+
+// NewFutureTxPool 创建空的暂存池
+func NewFutureTxPool() *FutureTxPool {
+	return &FutureTxPool{bySender: make(map[string]map[uint64]Transaction)}
+}
+
+// Add 暂存交易，next 为发送方下一笔交易应使用的 nonce。nonce 不超前、超前过多、
+// 同一 nonce 已有暂存的交易或池已满时返回 false
+func (p *FutureTxPool) Add(tx Transaction, next uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tx.Nonce <= next || tx.Nonce-next > maxFutureTxsPerSender || p.size >= maxFutureTxs {
+		return false
+	}
+	pending := p.bySender[tx.Sender]
+	if pending == nil {
+		pending = make(map[uint64]Transaction)
+		p.bySender[tx.Sender] = pending
+	}
+	if _, exists := pending[tx.Nonce]; exists || len(pending) >= maxFutureTxsPerSender {
+		return false
+	}
+	pending[tx.Nonce] = tx
+	p.size++
+	return true
+}
+
+// Take 取出发送方 nonce 为 nonce 的交易，同时丢弃该发送方 nonce 更小、已无法加入交易池的交易
+func (p *FutureTxPool) Take(sender string, nonce uint64) (Transaction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending := p.bySender[sender]
+	for n := range pending {
+		if n < nonce {
+			delete(pending, n)
+			p.size--
+		}
+	}
+	tx, exists := pending[nonce]
+	if exists {
+		delete(pending, nonce)
+		p.size--
+	}
+	if len(pending) == 0 {
+		delete(p.bySender, sender)
+	}
+	return tx, exists
+}
+
+// Senders 返回有暂存交易的发送方，按名称排序
+func (p *FutureTxPool) Senders() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	senders := make([]string, 0, len(p.bySender))
+	for sender := range p.bySender {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+	return senders
+}
+
+// Len 返回暂存的交易数
+func (p *FutureTxPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// holdFutureTx 校验签名后暂存 nonce 超前的交易，调用方需持有 node.mu
+func (node *Node) holdFutureTx(tx Transaction) {
+	bc := node.Blockchain
+	publicKey, exists := node.PublicKeys[tx.Sender]
+	if !exists || !bc.Params.acceptsTxVersion(tx.Sender, tx.Version) || !VerifyTransaction(&tx, bc.Params.ChainID, publicKey) {
+		fmt.Printf("交易验证失败: %+v\n", tx)
+		return
+	}
+	next := bc.NextNonce(tx.Sender)
+	if !node.FutureTxs.Add(tx, next) {
+		fmt.Printf("交易 nonce 超前过多或暂存池已满，已忽略: 期望 %d, 实际 %d\n", next, tx.Nonce)
+		return
+	}
+	fmt.Printf("交易 nonce 超前，暂存等待此前的交易: 期望 %d, 实际 %d\n", next, tx.Nonce)
+}
+
+// promoteFutureTxs 将发送方暂存的交易按 nonce 顺序依次加入交易池，返回加入的交易。调用方需持有 node.mu
+func (node *Node) promoteFutureTxs(sender, filePath string) []Transaction {
+	var promoted []Transaction
+	for {
+		tx, exists := node.FutureTxs.Take(sender, node.Blockchain.NextNonce(sender))
+		if !exists || !node.Blockchain.AddTransactionToPool(tx, node.PublicKeys, filePath) {
+			return promoted
+		}
+		promoted = append(promoted, tx)
+	}
+}
+
+// retryFutureTxs 区块接入主链后，尝试把全部发送方暂存的交易加入交易池并宣告。调用方需持有 node.mu
+func (node *Node) retryFutureTxs() {
+	for _, sender := range node.FutureTxs.Senders() {
+		for _, tx := range node.promoteFutureTxs(sender, node.transactionPoolFile()) {
+			node.announce(InvVector{InvTypeTx, tx.ID()})
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"gamechain/coin"
+	"testing"
+)
+
+func TestFutureTxPoolLimits(t *testing.T) {
+	pool := NewFutureTxPool()
+	tx := func(nonce uint64) Transaction { return Transaction{Sender: "Alice", Nonce: nonce} }
+
+	if pool.Add(tx(1), 1) || pool.Add(tx(1+maxFutureTxsPerSender+1), 1) {
+		t.Error("nonce 不超前或超前过多的交易不应暂存")
+	}
+	if !pool.Add(tx(3), 1) || pool.Add(tx(3), 1) || !pool.Add(tx(4), 1) {
+		t.Fatal("超前的交易应暂存，同一 nonce 只暂存一笔")
+	}
+
+	// 取出 nonce 4 时丢弃已无法加入交易池的 nonce 3
+	if got, ok := pool.Take("Alice", 4); !ok || got.Nonce != 4 {
+		t.Errorf("应取出 nonce 4 的交易: %+v", got)
+	}
+	if pool.Len() != 0 || len(pool.Senders()) != 0 {
+		t.Errorf("取出后暂存池应为空: %d", pool.Len())
+	}
+}
+
+func TestOutOfOrderTransactionsReachPool(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	chdirTemp(t)
+	node := newTestNode(bc, publicKeys)
+	txs := make([]Transaction, 3)
+	for i := range txs {
+		txs[i] = NewTransaction(testParams.ChainID, "Alice", "Bob", coin.Coin, 0, uint64(i+1), privateKeys["Alice"])
+	}
+
+	// nonce 3、2 先于 nonce 1 到达
+	for _, tx := range []Transaction{txs[2], txs[1]} {
+		if accepted := node.HandleNewTransaction(tx); len(accepted) != 0 {
+			t.Fatalf("nonce 超前的交易不应直接进入交易池: %+v", accepted)
+		}
+	}
+	accepted := node.HandleNewTransaction(txs[0])
+	if len(accepted) != 3 || accepted[1].ID() != txs[1].ID() || accepted[2].ID() != txs[2].ID() {
+		t.Fatalf("缺失的交易到达后暂存的交易应依次加入交易池: %+v", accepted)
+	}
+	if len(bc.TransactionPool) != 3 || node.FutureTxs.Len() != 0 {
+		t.Errorf("交易池应有 3 笔交易, 实际 %d 笔, 暂存 %d 笔", len(bc.TransactionPool), node.FutureTxs.Len())
+	}
+
+	// nonce 1 由区块打包后，此前暂存的 nonce 2 随区块接入进入交易池
+	other := newTestNode(bc.withBlocks(append([]Block(nil), bc.Blocks[:1]...)), publicKeys)
+	if accepted := other.HandleNewTransaction(txs[1]); len(accepted) != 0 {
+		t.Fatal("nonce 超前的交易应暂存")
+	}
+	block := bc.newBlockOnTip([]Transaction{txs[0]}, "Alice", publicKeys)
+	block.ProofOfWork()
+	if err := other.HandleNewBlock(block, ""); err != nil {
+		t.Fatal(err)
+	}
+	if pool := other.Blockchain.TransactionPool; len(pool) != 1 || pool[0].ID() != txs[1].ID() {
+		t.Errorf("区块接入后暂存的交易应进入交易池: %+v", pool)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gamechain/coin"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// GenesisConfig genesis.json 描述的创世配置。加载同一份配置的节点生成完全相同的创世区块
+type GenesisConfig struct {
+	ChainID          string                 `json:"chain_id"`
+	Bits             string                 `json:"bits"`      // 初始难度目标，紧凑格式的十六进制，例如 "0x2000ffff"
+	Timestamp        int64                  `json:"timestamp"` // 创世区块的时间戳（Unix 秒）
+	InitialSubsidy   coin.Amount            `json:"initial_subsidy"`
+	HalvingInterval  int                    `json:"halving_interval"`
+	MaxSupply        coin.Amount            `json:"max_supply"`
+	CoinbaseMaturity int                    `json:"coinbase_maturity"`
+	Alloc            map[string]coin.Amount `json:"alloc"` // 创世区块中预先分配给各账户的余额
+	UTXO             bool                   `json:"utxo"`  // 是否使用 UTXO 账本模式
+}
+
+// LoadGenesis 读取创世配置文件，在 base 的基础上覆盖链 ID、初始难度、创世时间戳、出块奖励规则、预分配余额和账本模式，
+// 难度调整等其余参数沿用 base
+func LoadGenesis(filePath string, base *ChainParams) (*ChainParams, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取创世配置失败: %w", err)
+	}
+	var config GenesisConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("解析创世配置 %s 失败: %w", filePath, err)
+	}
+	params, err := config.apply(base)
+	if err != nil {
+		return nil, fmt.Errorf("创世配置 %s 无效: %w", filePath, err)
+	}
+	return params, nil
+}
+
+// apply 校验配置并返回覆盖后的网络参数副本
+func (c *GenesisConfig) apply(base *ChainParams) (*ChainParams, error) {
+	if c.ChainID == "" {
+		return nil, fmt.Errorf("缺少 chain_id")
+	}
+	// 沿用内置网络的链 ID 时，未加载该配置的节点会以相同的链 ID 生成不同的创世区块
+	for name, network := range networks {
+		if c.ChainID == network.ChainID {
+			return nil, fmt.Errorf("chain_id %s 已被内置网络 %s 使用，自定义创世配置需使用新的链 ID", c.ChainID, name)
+		}
+	}
+	bits, err := strconv.ParseUint(c.Bits, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bits 格式不正确: %q", c.Bits)
+	}
+	if target := CompactToBig(uint32(bits)); target.Sign() <= 0 || target.Cmp(CompactToBig(base.PowLimitBits)) > 0 {
+		return nil, fmt.Errorf("bits 0x%08x 超出允许范围 (最低难度 0x%08x)", bits, base.PowLimitBits)
+	}
+	if c.InitialSubsidy < 0 || c.MaxSupply < 0 || c.HalvingInterval <= 0 || c.CoinbaseMaturity < 0 {
+		return nil, fmt.Errorf("出块奖励规则不正确")
+	}
+	var total coin.Amount
+	for name, amount := range c.Alloc {
+		if name == "" || name == "System" {
+			return nil, fmt.Errorf("不能向账户 %q 预分配余额", name)
+		}
+		if amount <= 0 {
+			return nil, fmt.Errorf("账户 %s 的预分配金额必须为正: %s", name, amount)
+		}
+		if total, err = total.Add(amount); err != nil {
+			return nil, fmt.Errorf("预分配总额溢出: %w", err)
+		}
+	}
+
+	params := *base
+	params.ChainID = c.ChainID
+	params.InitialBits = uint32(bits)
+	params.GenesisTimestamp = c.Timestamp
+	params.InitialSubsidy = c.InitialSubsidy
+	params.HalvingInterval = c.HalvingInterval
+	params.MaxSupply = c.MaxSupply
+	params.CoinbaseMaturity = c.CoinbaseMaturity
+	params.GenesisAlloc = c.Alloc
+	params.UTXO = c.UTXO
+	return &params, nil
+}
+
+// genesisTemplate 按网络参数创建尚未挖矿的创世区块。预分配余额按账户名排序写成系统发出的交易，
+// 区块头记录固定的时间戳和执行预分配后的状态根
+func genesisTemplate(params *ChainParams) Block {
+	names := make([]string, 0, len(params.GenesisAlloc))
+	for name := range params.GenesisAlloc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	allocations := make([]Transaction, 0, len(names))
+	for _, name := range names {
+		allocations = append(allocations, Transaction{
+			Version:  currentTxVersion,
+			Sender:   "System",
+			Receiver: name,
+			Amount:   params.GenesisAlloc[name],
+		})
+	}
+
+	genesisBlock := newBlockTemplate(
+		0,                  // 区块编号
+		zeroHash,           // 前一区块的哈希（创世区块无前区块）
+		allocations,        // 预分配余额
+		"System",           // 矿工账户（系统账户）
+		0,                  // 奖励（创世区块无奖励）
+		params.InitialBits, // 难度目标
+	)
+	genesisBlock.Header.Timestamp = params.GenesisTimestamp
+	l := newLedger(&genesisBlock, params)
+	l.applyBlock(&genesisBlock)
+	genesisBlock.Header.StateRoot = l.stateRoot()
+	return genesisBlock
+}
+
+// newGenesisBlock 创建并挖出创世区块。挖矿只使用一个协程从 nonce 0 开始顺序搜索，
+// 因此相同的网络参数总是得到相同的创世区块哈希
+func newGenesisBlock(params *ChainParams) Block {
+	genesisBlock := genesisTemplate(params)
+	if _, err := genesisBlock.Mine(context.Background(), 1); err != nil {
+		panic(fmt.Sprintf("挖矿失败: %v", err))
+	}
+	return genesisBlock
+}
+
+// validateGenesis 校验新格式的创世区块与网络参数生成的创世区块一致（nonce 只需满足工作量证明）
+func validateGenesis(block *Block, params *ChainParams) error {
+	expected := genesisTemplate(params)
+	expected.Header.Nonce = block.Header.Nonce
+	if block.Header != expected.Header {
+		return fmt.Errorf("创世区块与创世配置不一致")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"gamechain/coin"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeGenesis(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadGenesis(t *testing.T) {
+	path := writeGenesis(t, `{
+		"chain_id": "gamechain-dev",
+		"bits": "0x200fffff",
+		"timestamp": 1700000000,
+		"initial_subsidy": 25,
+		"halving_interval": 500,
+		"max_supply": 21000,
+		"coinbase_maturity": 2,
+		"alloc": {"Alice": 100.5, "Bob": "30"}
+	}`)
+	params, err := LoadGenesis(path, testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.ChainID != "gamechain-dev" || params.InitialBits != 0x200fffff || params.GenesisTimestamp != 1700000000 {
+		t.Errorf("链 ID、难度或时间戳不正确: %+v", params)
+	}
+	if params.InitialSubsidy != 25*coin.Coin || params.HalvingInterval != 500 || params.MaxSupply != 21000*coin.Coin || params.CoinbaseMaturity != 2 {
+		t.Errorf("出块奖励规则不正确: %+v", params)
+	}
+	if params.RetargetInterval != testParams.RetargetInterval || testParams.ChainID != "gamechain-unit" {
+		t.Error("未覆盖的参数应沿用基础网络，且不能修改基础网络")
+	}
+
+	// 相同配置生成相同的创世区块，预分配余额写入创世状态
+	genesis := newGenesisBlock(params)
+	if again := newGenesisBlock(params); again.Hash != genesis.Hash {
+		t.Errorf("相同配置生成的创世区块哈希不同: %s, %s", genesis.Hash, again.Hash)
+	}
+	bc := &Blockchain{Params: params, Blocks: []Block{genesis}}
+	l := bc.chainLedger()
+	if l.balances["Alice"] != 100*coin.Coin+coin.Coin/2 || l.balances["Bob"] != 30*coin.Coin {
+		t.Errorf("预分配余额不正确: %v", l.balances)
+	}
+	if err := bc.ValidateChain(nil); err != nil {
+		t.Errorf("创世区块应通过校验: %v", err)
+	}
+}
+
+func TestLoadGenesisRejectsInvalidConfig(t *testing.T) {
+	valid := `"chain_id": "dev", "bits": "0x200fffff", "initial_subsidy": 50, "halving_interval": 100, "max_supply": 1000`
+	tests := map[string]string{
+		"缺少链 ID":      `{"bits": "0x200fffff", "halving_interval": 100}`,
+		"沿用内置网络的链 ID": `{"chain_id": "gamechain-main", "bits": "0x200fffff", "initial_subsidy": 50, "halving_interval": 100, "max_supply": 1000}`,
+		"难度超出范围":      `{"chain_id": "dev", "bits": "0x2100ffff", "halving_interval": 100}`,
+		"减半周期为 0":     `{"chain_id": "dev", "bits": "0x200fffff"}`,
+		"预分配给系统":      `{` + valid + `, "alloc": {"System": 1}}`,
+		"预分配金额为负":     `{` + valid + `, "alloc": {"Alice": -1}}`,
+	}
+	for name, content := range tests {
+		if _, err := LoadGenesis(writeGenesis(t, content), testParams); err == nil {
+			t.Errorf("%s: 期望返回错误", name)
+		}
+	}
+}
+
+func TestValidateChainRejectsForeignGenesis(t *testing.T) {
+	params := *testParams
+	params.GenesisAlloc = map[string]coin.Amount{"Alice": 1000 * coin.Coin}
+	bc := &Blockchain{Params: testParams, Blocks: []Block{newGenesisBlock(&params)}}
+
+	err := bc.ValidateChain(nil)
+	if err == nil || !strings.Contains(err.Error(), "创世") {
+		t.Fatalf("期望创世区块校验失败, 实际: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	legacyBlockVersion    = 0 // 旧格式：以字符串拼接的区块头计算哈希，难度固定
+	binaryBlockVersion    = 1 // 定长二进制区块头
+	stateRootBlockVersion = 2 // 二进制区块头末尾增加账户状态根
+	currentBlockVersion   = stateRootBlockVersion
+
+	binaryHeaderSize = 96  // 版本 1 区块头的编码长度
+	headerSize       = 128 // 版本 2 区块头的编码长度
+	nonceOffset      = 84  // Nonce 在编码中的偏移（各版本相同），挖矿时只需改写这 8 个字节
+)
+
+// 新格式创世区块的前一区块哈希
+var zeroHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// HasStateRoot 判断区块头是否记录账户状态根
+func (h *BlockHeader) HasStateRoot() bool {
+	return h.Version >= stateRootBlockVersion
+}
+
+// encodedSize 返回区块头按其版本编码后的长度
+func encodedSize(version uint32) int {
+	if version >= stateRootBlockVersion {
+		return headerSize
+	}
+	return binaryHeaderSize
+}
+
+// MarshalBinary 将区块头编码为定长的大端序二进制格式，用于计算区块哈希和在 headers 消息中传输区块头：
+//
+//	Version(4) | Index(8) | Timestamp(8) | PreviousHash(32) | MerkleRoot(32) | Nonce(8) | Bits(4) | StateRoot(32)
+//
+// 旧格式和版本 1 的区块头没有 StateRoot。旧格式区块头的编码只用于传输，其哈希仍按字符串拼接计算
+func (h *BlockHeader) MarshalBinary() ([]byte, error) {
+	if h.Index < 0 {
+		return nil, fmt.Errorf("区块编号为负: %d", h.Index)
+	}
+	previousHash, err := decodeHash(h.PreviousHash)
+	if err != nil {
+		return nil, fmt.Errorf("前一区块哈希格式错误: %w", err)
+	}
+	merkleRoot, err := decodeHash(h.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("Merkle 根格式错误: %w", err)
+	}
+
+	buf := make([]byte, 0, encodedSize(h.Version))
+	buf = binary.BigEndian.AppendUint32(buf, h.Version)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Index))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Timestamp))
+	buf = append(buf, previousHash...)
+	buf = append(buf, merkleRoot...)
+	buf = binary.BigEndian.AppendUint64(buf, h.Nonce)
+	buf = binary.BigEndian.AppendUint32(buf, h.Bits)
+	if h.HasStateRoot() {
+		stateRoot, err := decodeHash(h.StateRoot)
+		if err != nil {
+			return nil, fmt.Errorf("状态根格式错误: %w", err)
+		}
+		buf = append(buf, stateRoot...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary 从 MarshalBinary 的编码中解析区块头，长度必须与版本对应的编码长度完全一致
+func (h *BlockHeader) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize {
+		return fmt.Errorf("区块头长度错误: 至少 %d 字节, 实际 %d 字节", binaryHeaderSize, len(data))
+	}
+	version := binary.BigEndian.Uint32(data[0:4])
+	if version > currentBlockVersion {
+		return fmt.Errorf("不支持的区块头版本: %d", version)
+	}
+	if size := encodedSize(version); len(data) != size {
+		return fmt.Errorf("区块头长度错误: 期望 %d 字节, 实际 %d 字节", size, len(data))
+	}
+	index := binary.BigEndian.Uint64(data[4:12])
+	if index > uint64(^uint(0)>>1) {
+		return fmt.Errorf("区块编号溢出: %d", index)
+	}
+
+	*h = BlockHeader{
+		Version:      version,
+		Index:        int(index),
+		Timestamp:    int64(binary.BigEndian.Uint64(data[12:20])),
+		PreviousHash: hex.EncodeToString(data[20:52]),
+		MerkleRoot:   hex.EncodeToString(data[52:84]),
+		Nonce:        binary.BigEndian.Uint64(data[nonceOffset:92]),
+		Bits:         binary.BigEndian.Uint32(data[92:96]),
+	}
+	if h.HasStateRoot() {
+		h.StateRoot = hex.EncodeToString(data[96:128])
+	}
+	return nil
+}
+
+// legacyHeaderData 返回旧格式区块参与哈希计算的字符串，仅用于校验 blockchain.json 中已有的区块
+func (h *BlockHeader) legacyHeaderData() []byte {
+	return []byte(fmt.Sprintf("%d%d%s%d%s", h.Index, h.Timestamp, h.PreviousHash, h.Nonce, h.MerkleRoot))
+}
+
+// decodeHash 将十六进制哈希解码为 32 字节
+func decodeHash(hash string) ([]byte, error) {
+	data, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != sha256.Size {
+		return nil, fmt.Errorf("哈希长度应为 %d 字节, 实际 %d 字节", sha256.Size, len(data))
+	}
+	return data, nil
+}
+
+// hashMeetsTarget 判断按大端序解释的哈希是否不大于目标值
+func hashMeetsTarget(hash, target []byte) bool {
+	return bytes.Compare(hash, target) <= 0
+}
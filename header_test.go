@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlockHeaderBinaryRoundTrip(t *testing.T) {
+	for _, version := range []uint32{legacyBlockVersion, binaryBlockVersion, stateRootBlockVersion} {
+		header := BlockHeader{
+			Version:      version,
+			Index:        42,
+			Timestamp:    1733759024,
+			PreviousHash: strings.Repeat("ab", 32),
+			Nonce:        1<<63 + 7,
+			MerkleRoot:   strings.Repeat("cd", 32),
+			Bits:         0x2000ffff,
+		}
+		if header.HasStateRoot() {
+			header.StateRoot = strings.Repeat("ef", 32)
+		}
+		data, err := header.MarshalBinary()
+		if err != nil {
+			t.Fatalf("版本 %d 编码失败: %v", version, err)
+		}
+		if size := encodedSize(version); len(data) != size {
+			t.Fatalf("版本 %d 编码长度应为 %d, 实际 %d", version, size, len(data))
+		}
+
+		var decoded BlockHeader
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("版本 %d 解码失败: %v", version, err)
+		}
+		if decoded != header {
+			t.Errorf("解码结果不一致:\n实际: %+v\n期望: %+v", decoded, header)
+		}
+
+		if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+			t.Errorf("版本 %d 长度错误的编码应解码失败", version)
+		}
+	}
+
+	unsupported := BlockHeader{Version: currentBlockVersion + 1, PreviousHash: zeroHash, MerkleRoot: zeroHash, StateRoot: zeroHash}
+	data, err := unsupported.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded BlockHeader
+	if err := decoded.UnmarshalBinary(data); err == nil {
+		t.Error("不支持的版本应解码失败")
+	}
+}
+
+func TestBlockHeaderFieldBoundaries(t *testing.T) {
+	// 旧格式下 Index=1,Timestamp=23 与 Index=12,Timestamp=3 拼接出相同的字符串
+	a := Block{Header: BlockHeader{Index: 1, Timestamp: 23, PreviousHash: zeroHash, MerkleRoot: zeroHash}}
+	b := Block{Header: BlockHeader{Index: 12, Timestamp: 3, PreviousHash: zeroHash, MerkleRoot: zeroHash}}
+	if a.CalculateHash() != b.CalculateHash() {
+		t.Fatal("旧格式哈希应存在字段边界冲突")
+	}
+
+	a.Header.Version, b.Header.Version = currentBlockVersion, currentBlockVersion
+	a.Header.StateRoot, b.Header.StateRoot = zeroHash, zeroHash
+	if a.CalculateHash() == b.CalculateHash() {
+		t.Error("二进制区块头不应存在字段边界冲突")
+	}
+}
+
+func TestLegacyBlockHashStillVerifies(t *testing.T) {
+	// blockchain.json 中已有的旧格式创世区块
+	genesis := Block{
+		Header: BlockHeader{
+			Index:        0,
+			Timestamp:    1733755339,
+			PreviousHash: "0",
+			Nonce:        57,
+			MerkleRoot:   "",
+		},
+		Hash: "009d9f4584ac023f96051831420efae2e993117c1f645effba41343d8fb16be4",
+	}
+	if !genesis.HasValidProofOfWork() {
+		t.Errorf("旧格式区块校验失败, 计算哈希: %s", genesis.CalculateHash())
+	}
+}
+
+func TestProofOfWorkMatchesCalculateHash(t *testing.T) {
+	block := NewBlock(1, zeroHash, []Transaction{}, "Alice", testParams.InitialSubsidy, testParams.InitialBits)
+	if block.Hash != block.CalculateHash() || !block.HasValidProofOfWork() {
+		t.Errorf("挖出的区块哈希 %s 校验失败", block.Hash)
+	}
+}
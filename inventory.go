@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 库存广播参数
+const (
+	maxInvPerMessage = 1000             // 每条 inv、getdata 和 notfound 最多包含的条目数
+	maxKnownInv      = 5000             // 每个会话记录的对方已知条目数，超过时淘汰最早的条目
+	getDataTimeout   = 30 * time.Second // getdata 发出后未收到回复时，可以向其他节点重新请求
+)
+
+// inventorySet 容量有限的库存条目集合，超过容量时按加入顺序淘汰最早的条目
+type inventorySet struct {
+	mu    sync.Mutex
+	items map[InvVector]bool
+	order []InvVector // 循环记录加入顺序
+	next  int         // order 中下一个写入的位置
+}
+
+func newInventorySet(capacity int) *inventorySet {
+	return &inventorySet{items: make(map[InvVector]bool), order: make([]InvVector, 0, capacity)}
+}
+
+// Add 加入条目，返回条目此前是否不在集合中
+func (s *inventorySet) Add(item InvVector) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.items[item] {
+		return false
+	}
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, item)
+	} else {
+		delete(s.items, s.order[s.next])
+		s.order[s.next] = item
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.items[item] = true
+	return true
+}
+
+// Has 判断条目是否在集合中
+func (s *inventorySet) Has(item InvVector) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[item]
+}
+
+// announce 向尚未知道该条目的会话发送 inv，并记为对方已知，返回发送的会话数。
+// 每个节点只在第一次接受交易或区块时宣告，因此消息经多跳转发到整个网络后停止
+func (node *Node) announce(item InvVector) int {
+	count := 0
+	for _, p := range node.Peers() {
+		if p.known.Add(item) && p.Send(InvMessage{Items: []InvVector{item}}) {
+			count++
+		}
+	}
+	return count
+}
+
+// haveInventory 判断本节点是否已有该条目：交易在主链或交易池中，区块在主链上或孤块池中
+func (node *Node) haveInventory(item InvVector) bool {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	switch item.Type {
+	case InvTypeTx:
+		_, _, found := node.Blockchain.FindTransaction(item.Hash)
+		return found
+	case InvTypeBlock:
+		return node.Blockchain.FindBlock(item.Hash) >= 0 || node.Orphans.Has(item.Hash)
+	}
+	// 未知类型的条目不请求
+	return true
+}
+
+// markRequested 记录向某个节点请求了该条目，已在 getDataTimeout 内请求过时返回 false，避免向多个节点重复请求
+func (node *Node) markRequested(item InvVector) bool {
+	node.peerMu.Lock()
+	defer node.peerMu.Unlock()
+	if node.requested == nil {
+		node.requested = make(map[InvVector]time.Time)
+	}
+	now := time.Now()
+	if requestedAt, exists := node.requested[item]; exists && now.Sub(requestedAt) < getDataTimeout {
+		return false
+	}
+	// 清理超时未回复的请求
+	if len(node.requested) >= maxInvPerMessage {
+		for pending, requestedAt := range node.requested {
+			if now.Sub(requestedAt) >= getDataTimeout {
+				delete(node.requested, pending)
+			}
+		}
+	}
+	node.requested[item] = now
+	return true
+}
+
+// received 清除条目的请求记录，收到条目或对方回复 notfound 后调用
+func (node *Node) received(item InvVector) {
+	node.peerMu.Lock()
+	defer node.peerMu.Unlock()
+	delete(node.requested, item)
+}
+
+// requestData 向节点请求条目，跳过正在向其他节点请求的条目，每条 getdata 最多 maxInvPerMessage 条
+func (node *Node) requestData(p *Peer, items []InvVector) {
+	var missing []InvVector
+	for _, item := range items {
+		if node.markRequested(item) {
+			missing = append(missing, item)
+		}
+	}
+	for len(missing) > 0 {
+		n := min(len(missing), maxInvPerMessage)
+		p.Send(GetDataMessage{Items: missing[:n]})
+		missing = missing[n:]
+	}
+}
+
+// handleInv 记录对方拥有的条目，并请求本节点缺少的条目
+func (node *Node) handleInv(p *Peer, msg InvMessage) {
+	if len(msg.Items) > maxInvPerMessage {
+		node.misbehaving(p, 20, fmt.Sprintf("inv 消息包含 %d 个条目", len(msg.Items)))
+		return
+	}
+	var missing []InvVector
+	for _, item := range msg.Items {
+		p.known.Add(item)
+		if !node.haveInventory(item) {
+			missing = append(missing, item)
+		}
+	}
+	node.requestData(p, missing)
+}
+
+// inventoryMessage 返回条目的完整内容，本节点没有时返回 nil
+func (node *Node) inventoryMessage(item InvVector) Message {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	switch item.Type {
+	case InvTypeTx:
+		if tx, _, found := node.Blockchain.FindTransaction(item.Hash); found {
+			return TxMessage{Transaction: tx}
+		}
+	case InvTypeBlock:
+		if index := node.Blockchain.FindBlock(item.Hash); index >= 0 {
+			return BlockMessage{Block: node.Blockchain.Blocks[index]}
+		}
+	}
+	return nil
+}
+
+// handleGetData 逐条返回请求的交易和区块，没有的条目汇总为一条 notfound
+func (node *Node) handleGetData(p *Peer, msg GetDataMessage) {
+	if len(msg.Items) > maxInvPerMessage {
+		node.misbehaving(p, 20, fmt.Sprintf("getdata 消息包含 %d 个条目", len(msg.Items)))
+		return
+	}
+	var notFound []InvVector
+	for _, item := range msg.Items {
+		reply := node.inventoryMessage(item)
+		if reply == nil {
+			notFound = append(notFound, item)
+			continue
+		}
+		p.known.Add(item)
+		if !p.Send(reply) {
+			return
+		}
+	}
+	if len(notFound) > 0 {
+		p.Send(NotFoundMessage{Items: notFound})
+	}
+}
+
+// handleTx 处理对方发来的交易，进入交易池的交易（包括因此依次加入的暂存交易）继续宣告给其他节点
+func (node *Node) handleTx(p *Peer, tx Transaction) {
+	item := InvVector{InvTypeTx, tx.ID()}
+	p.known.Add(item)
+	node.received(item)
+	for _, accepted := range node.HandleNewTransaction(tx) {
+		node.announce(InvVector{InvTypeTx, accepted.ID()})
+	}
+}
+
+// handleBlock 处理对方发来的区块，链尾因此变化时把新链尾宣告给其他节点。
+// 区块不合法时增加对方的惩罚分，时间戳超前本地时间的区块可能只是时钟偏差，不惩罚
+func (node *Node) handleBlock(p *Peer, block Block) {
+	item := InvVector{InvTypeBlock, block.Hash}
+	p.known.Add(item)
+	p.updateHeight(block.Header.Index)
+	node.received(item)
+
+	node.mu.Lock()
+	before := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1].Hash
+	node.mu.Unlock()
+	if err := node.HandleNewBlock(block, p.Addr); err != nil && isPeerFault(err) {
+		node.misbehaving(p, 50, err.Error())
+	}
+	node.mu.Lock()
+	after := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1].Hash
+	node.mu.Unlock()
+	if after != before {
+		node.announce(InvVector{InvTypeBlock, after})
+	}
+}
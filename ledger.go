@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"gamechain/coin"
+	"sort"
+)
+
+// ledger 记录按顺序重放交易得到的账户余额、每个账户最近使用的交易 nonce 以及尚未成熟的奖励
+type ledger struct {
+	balances map[string]coin.Amount
+	nonces   map[string]uint64
+	initial  map[string]coin.Amount // 账户的初始余额，不在其中的账户初始余额为 0
+	height   int                    // 接下来执行的交易所在区块的高度
+	maturity int                    // 奖励需要经过的区块数
+	immature []immatureReward       // 尚未成熟、不能花费的奖励
+	utxos    *UTXOSet               // UTXO 模式下尚未花费的输出，账户模式下为 nil
+}
+
+// immatureReward 新格式区块中的奖励交易，在高度 height+maturity 之前不能被花费
+type immatureReward struct {
+	account string
+	amount  coin.Amount
+	height  int
+}
+
+// newLedger 创建执行创世区块之前的账本，奖励成熟期和账本模式取自网络参数。
+// 新格式创世区块通过其中的交易预分配余额，账本从空状态开始；
+// 旧格式创世区块的链为网络参数中固定的 LegacyAccounts 发放初始余额，与本地的账户文件无关
+func newLedger(genesis *Block, params *ChainParams) *ledger {
+	l := &ledger{
+		balances: make(map[string]coin.Amount),
+		nonces:   make(map[string]uint64),
+		initial:  make(map[string]coin.Amount),
+		maturity: params.CoinbaseMaturity,
+	}
+	if params.UTXO {
+		l.utxos = NewUTXOSet(params.CoinbaseMaturity)
+	}
+	if !genesis.Header.IsLegacy() {
+		return l
+	}
+	for _, name := range params.LegacyAccounts {
+		l.balances[name] = initialBalance
+		l.initial[name] = initialBalance
+	}
+	return l
+}
+
+// chainLedger 从创世状态开始重放主链上的全部区块（主链视为已通过校验）
+func (bc *Blockchain) chainLedger() *ledger {
+	l := newLedger(&bc.Blocks[0], bc.Params)
+	for i := range bc.Blocks {
+		l.applyBlock(&bc.Blocks[i])
+	}
+	return l
+}
+
+// pendingLedger 在主链账本的基础上依次执行交易池中的交易，得到下一个区块打包前的状态
+func (bc *Blockchain) pendingLedger() *ledger {
+	l := bc.chainLedger()
+	for _, tx := range bc.TransactionPool {
+		l.applyTransaction(tx, true)
+	}
+	return l
+}
+
+// applyBlock 按顺序执行区块中的交易。旧格式区块中的交易没有 nonce，不做 nonce 检查，
+// 其奖励也不受成熟期限制
+func (l *ledger) applyBlock(block *Block) error {
+	l.height = block.Header.Index
+	kept := 0
+	for _, reward := range l.immature {
+		if reward.height+l.maturity > l.height {
+			l.immature[kept] = reward
+			kept++
+		}
+	}
+	l.immature = l.immature[:kept]
+
+	for i, tx := range block.Transactions {
+		coinbase := !block.Header.IsLegacy() && i == len(block.Transactions)-1
+		if err := l.apply(tx, !block.Header.IsLegacy(), coinbase); err != nil {
+			return err
+		}
+	}
+	if !block.Header.IsLegacy() && len(block.Transactions) > 0 {
+		coinbase := block.Transactions[len(block.Transactions)-1]
+		l.immature = append(l.immature, immatureReward{coinbase.Receiver, coinbase.Amount, l.height})
+	}
+	// 之后执行的交易（例如交易池中的交易）属于下一个区块
+	l.height++
+	return nil
+}
+
+// locked 返回账户尚未成熟、不能花费的奖励总额
+func (l *ledger) locked(account string) coin.Amount {
+	var locked coin.Amount
+	for _, reward := range l.immature {
+		if reward.account == account && reward.height+l.maturity > l.height {
+			locked += reward.amount
+		}
+	}
+	return locked
+}
+
+// applyTransaction 执行单笔交易，发送方支付金额和手续费，手续费由区块的奖励交易转给矿工。
+// 余额不足、nonce 不连续或 UTXO 模式下输入无效时返回错误且不改变账本
+func (l *ledger) applyTransaction(tx Transaction, checkNonce bool) error {
+	return l.apply(tx, checkNonce, false)
+}
+
+// apply 执行单笔交易，coinbase 表示交易是区块的奖励交易，其输出需要成熟后才能花费
+func (l *ledger) apply(tx Transaction, checkNonce, coinbase bool) error {
+	if tx.Amount < 0 || tx.Fee < 0 {
+		return fmt.Errorf("交易金额或手续费为负: %s -> %s (金额: %s, 手续费: %s)", tx.Sender, tx.Receiver, tx.Amount, tx.Fee)
+	}
+	cost, err := tx.Cost()
+	if err != nil {
+		return fmt.Errorf("交易金额溢出: %w", err)
+	}
+	if tx.Sender == "System" && tx.Fee != 0 {
+		return fmt.Errorf("奖励交易不能包含手续费")
+	}
+	if tx.IsUTXO() && l.utxos == nil {
+		return fmt.Errorf("当前网络未启用 UTXO 模式")
+	}
+	if tx.IsBatch() {
+		if err := tx.checkOutputs(); err != nil {
+			return err
+		}
+	}
+	if l.utxos != nil && tx.Sender != "System" {
+		if !tx.IsUTXO() {
+			return fmt.Errorf("UTXO 模式只接受 UTXO 交易")
+		}
+		if err := l.utxos.checkInputs(&tx, l.height); err != nil {
+			return err
+		}
+	}
+	if tx.Sender != "System" {
+		// UTXO 交易由输入防止重放，不使用 nonce
+		if checkNonce && !tx.IsUTXO() {
+			if expected := l.nonces[tx.Sender] + 1; tx.Nonce != expected {
+				return fmt.Errorf("账户 %s 的交易 nonce 不正确: 期望 %d, 实际 %d", tx.Sender, expected, tx.Nonce)
+			}
+		}
+		if locked := l.locked(tx.Sender); l.balances[tx.Sender]-locked < cost {
+			return fmt.Errorf("账户 %s 可用余额不足 (余额: %s, 未成熟奖励: %s, 需要: %s)", tx.Sender, l.balances[tx.Sender], locked, cost)
+		}
+	}
+	credits := make(map[string]coin.Amount)
+	for _, out := range tx.outputs() {
+		if out.Amount < 0 {
+			return fmt.Errorf("交易输出金额为负: %s -> %s (金额: %s)", tx.Sender, out.Receiver, out.Amount)
+		}
+		credit, err := credits[out.Receiver].Add(out.Amount)
+		if err == nil {
+			_, err = l.balances[out.Receiver].Add(credit)
+		}
+		if err != nil {
+			return fmt.Errorf("账户 %s 余额溢出: %w", out.Receiver, err)
+		}
+		credits[out.Receiver] = credit
+	}
+
+	if tx.Sender != "System" {
+		l.balances[tx.Sender] -= cost
+		if tx.Nonce > l.nonces[tx.Sender] {
+			l.nonces[tx.Sender] = tx.Nonce
+		}
+	}
+	for receiver, credit := range credits {
+		l.balances[receiver] += credit
+	}
+	if l.utxos != nil {
+		l.utxos.applyTransaction(&tx, l.height, coinbase)
+	}
+	return nil
+}
+
+// chainNonces 返回主链上每个账户最近使用的交易 nonce
+func (bc *Blockchain) chainNonces() map[string]uint64 {
+	nonces := make(map[string]uint64)
+	for _, block := range bc.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.Sender != "System" && tx.Nonce > nonces[tx.Sender] {
+				nonces[tx.Sender] = tx.Nonce
+			}
+		}
+	}
+	return nonces
+}
+
+// NextNonce 返回账户下一笔交易应使用的 nonce，已在交易池中的交易也计算在内
+func (bc *Blockchain) NextNonce(account string) uint64 {
+	nonce := bc.chainNonces()[account]
+	for _, tx := range bc.TransactionPool {
+		if tx.Sender == account && tx.Nonce > nonce {
+			nonce = tx.Nonce
+		}
+	}
+	return nonce + 1
+}
+
+// stateRoot 返回账本中全部账户状态的 Merkle 承诺
+func (l *ledger) stateRoot() string {
+	return stateRoot(l.balances, l.nonces, l.initial)
+}
+
+// stateRoot 计算账户状态的 Merkle 根。账户按名称排序，每个叶子为
+// sha256(len(Name)(4) | Name | Balance(8) | Nonce(8))；仍处于初始状态（初始余额且 nonce 为 0）的账户不参与。
+// 初始余额只由链本身和网络参数决定，各节点对同一条链得到相同的状态根。没有账户时返回全零哈希
+func stateRoot(balances map[string]coin.Amount, nonces map[string]uint64, initial map[string]coin.Amount) string {
+	names := []string{}
+	for name, balance := range balances {
+		if name != "System" && (balance != initial[name] || nonces[name] != 0) {
+			names = append(names, name)
+		}
+	}
+	for name, nonce := range nonces {
+		if _, exists := balances[name]; !exists && nonce != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return zeroHash
+	}
+	sort.Strings(names)
+
+	leaves := make([]string, 0, len(names))
+	for _, name := range names {
+		leaf := appendLengthPrefixed(nil, name)
+		leaf = binary.BigEndian.AppendUint64(leaf, uint64(balances[name]))
+		leaf = binary.BigEndian.AppendUint64(leaf, nonces[name])
+		hash := sha256.Sum256(leaf)
+		leaves = append(leaves, hex.EncodeToString(hash[:]))
+	}
+	return merkleRoot(leaves)
+}
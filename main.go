@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"gamechain/account"
 	"os"
-	"strings"
 )
 
 // 入口函数
@@ -17,8 +16,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 初始化余额管理器
-	// 初始化余额管理器
+	// 初始化余额管理器，余额文件只是区块链状态的缓存
 	balanceManager := account.NewBalanceManager()
 
 	// 从文件加载余额数据
@@ -28,24 +26,38 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 为新账户设置默认余额
-	for _, acc := range accounts {
-		if _, exists := balanceManager.GetBalance(acc.Name); !exists {
-			balanceManager.SetBalance(acc.Name, 100.0) // 设置默认余额
+	// 解析命令行参数
+	address := flag.String("address", "localhost:8080", "节点地址")
+	peers := flag.String("peers", "", "逗号分隔的其他节点地址，始终与这些节点保持会话")
+	seeds := flag.String("seeds", "", "逗号分隔的种子节点地址，地址簿中没有可用地址时连接并获取更多地址")
+	outbound := flag.Int("outbound", defaultMaxOutbound, "目标出站会话数，不足时自动从地址簿中挑选节点连接")
+	network := flag.String("network", "main", "网络名称 (main 或 test)，决定难度调整等共识参数")
+	genesis := flag.String("genesis", "", "创世配置文件路径 (例如 genesis.json)，覆盖网络的链 ID、初始难度、出块奖励规则和预分配余额")
+	flag.Parse()
+
+	params, err := GetChainParams(*network)
+	if err != nil {
+		fmt.Printf("加载网络参数失败: %v\n", err)
+		os.Exit(1)
+	}
+	if *genesis != "" {
+		if params, err = LoadGenesis(*genesis, params); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	}
 
 	// 加载区块链并创建创世区块（如果尚未存在）
-	blockchain = initializeBlockchain(blockchainFile, 2)
-
-	// 解析命令行参数
-	address := flag.String("address", "localhost:8080", "节点地址")
-	peers := flag.String("peers", "", "逗号分隔的其他节点地址")
-	flag.Parse()
+	blockchain, err = initializeBlockchain(blockchainFile, params, publicKeys)
+	if err != nil {
+		fmt.Printf("加载区块链失败: %v\n", err)
+		os.Exit(1)
+	}
 
-	peerNodes := []string{}
-	if *peers != "" {
-		peerNodes = strings.Split(*peers, ",")
+	addrBook, err := LoadAddrBook(peersFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
 	// 初始化节点
@@ -53,9 +65,26 @@ func main() {
 		Address:         *address,
 		Blockchain:      blockchain,
 		TransactionPool: blockchain.TransactionPool,
-		PeerNodes:       peerNodes,
+		PeerNodes:       ParsePeers(*peers),
+		SeedNodes:       ParsePeers(*seeds),
+		MaxOutbound:     *outbound,
+		AddrBook:        addrBook,
 		PublicKeys:      publicKeys,
 		BalanceManager:  balanceManager, // 传递 BalanceManager
+		Orphans:         NewOrphanPool(maxOrphanBlocks, orphanBlockExpiry),
+		FutureTxs:       NewFutureTxPool(),
+	}
+
+	// 余额缓存与区块链不一致（例如缓存缺失或过期）时从区块链重建
+	node.mu.Lock()
+	consistent, err := node.rebuildBalances()
+	node.mu.Unlock()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !consistent {
+		fmt.Println("余额缓存与区块链不一致，已从区块链重建")
 	}
 
 	// 启动节点
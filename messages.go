@@ -0,0 +1,175 @@
+package main
+
+import "fmt"
+
+// 网络协议版本，写入每条消息的帧头，版本不同的消息会被拒绝
+const protocolVersion = 2
+
+// 消息命令，帧头中的命令名决定负载的消息类型
+const (
+	CommandVersion    = "version"    // 握手：交换协议版本、链 ID、创世区块哈希和最佳高度
+	CommandVerack     = "verack"     // 握手：确认对方的 version
+	CommandPing       = "ping"       // 保活，对方以相同 nonce 回复 pong
+	CommandPong       = "pong"       // 响应 ping
+	CommandGetAddr    = "getaddr"    // 请求对方地址簿中的节点地址
+	CommandAddr       = "addr"       // 响应 getaddr，或转发新得知的节点地址
+	CommandInv        = "inv"        // 宣告拥有的交易和区块
+	CommandGetData    = "getdata"    // 请求 inv 中宣告的交易和区块
+	CommandNotFound   = "notfound"   // getdata 请求的条目不存在
+	CommandTx         = "tx"         // 响应 getdata，返回一笔交易
+	CommandBlock      = "block"      // 响应 getdata，返回一个区块
+	CommandGetHeaders = "getheaders" // 按区块定位器请求主链上共同祖先之后的区块头
+	CommandHeaders    = "headers"    // 响应 getheaders
+	CommandGetBlocks  = "getblocks"  // 同步时按哈希批量请求区块
+	CommandBlocks     = "blocks"     // 响应 getblocks
+	CommandReject     = "reject"     // 拒绝无法处理的消息
+)
+
+// reject 消息的错误码
+const (
+	RejectMalformed = "malformed" // 消息帧或负载无法解析
+	RejectVersion   = "version"   // 不支持的协议版本
+	RejectUnknown   = "unknown"   // 未知命令
+	RejectHandshake = "handshake" // 握手未完成时发送了其他消息，或重复握手
+	RejectGenesis   = "genesis"   // 链 ID 或创世区块与本节点不同
+	RejectDuplicate = "duplicate" // 与该节点已有会话，或连接到了自己
+	RejectBanned    = "banned"    // 对方因惩罚分过高被封禁
+)
+
+// Message 节点之间传输的消息，负载为消息结构体的 JSON 编码
+type Message interface {
+	Command() string
+}
+
+// VersionMessage 建立会话时双方首先发送的消息
+type VersionMessage struct {
+	ProtocolVersion uint32
+	ChainID         string
+	GenesisHash     string
+	BestHeight      int    // 发送方主链链尾的高度
+	UserAgent       string // 发送方的客户端名称和版本
+	ListenAddr      string // 发送方的监听地址，入站会话以此标识对方
+	Nonce           uint64 // 发送方启动时生成的随机数，用于发现连接到自己
+}
+
+// VerackMessage 确认对方的 version 通过检查
+type VerackMessage struct{}
+
+// PingMessage 保活并测量往返延迟
+type PingMessage struct {
+	Nonce uint64
+}
+
+// PongMessage 响应 ping，Nonce 与 ping 相同
+type PongMessage struct {
+	Nonce uint64
+}
+
+// GetAddrMessage 请求对方地址簿中的节点地址，对方返回 addr
+type GetAddrMessage struct{}
+
+// NetAddress 节点的监听地址及最近一次见到该节点的时间（Unix 秒）
+type NetAddress struct {
+	Addr     string
+	LastSeen int64
+}
+
+// AddrMessage 一组节点地址，最多 maxAddrPerMessage 个
+type AddrMessage struct {
+	Addrs []NetAddress
+}
+
+// 库存条目的类型
+const (
+	InvTypeTx    = 1 // 交易，Hash 为交易 ID
+	InvTypeBlock = 2 // 区块，Hash 为区块哈希
+)
+
+// InvVector 按类型和哈希标识一笔交易或一个区块
+type InvVector struct {
+	Type uint32
+	Hash string
+}
+
+func (v InvVector) String() string {
+	if v.Type == InvTypeBlock {
+		return "区块 " + v.Hash
+	}
+	return "交易 " + v.Hash
+}
+
+// InvMessage 宣告发送方拥有的交易和区块，最多 maxInvPerMessage 条，收到的节点用 getdata 请求缺少的条目
+type InvMessage struct {
+	Items []InvVector
+}
+
+// GetDataMessage 请求交易和区块的完整内容，最多 maxInvPerMessage 条。
+// 对方逐条返回 tx 或 block，没有的条目在一条 notfound 中列出
+type GetDataMessage struct {
+	Items []InvVector
+}
+
+// NotFoundMessage getdata 请求的条目中对方没有的部分
+type NotFoundMessage struct {
+	Items []InvVector
+}
+
+// TxMessage 一笔交易
+type TxMessage struct {
+	Transaction Transaction
+}
+
+// BlockMessage 一个区块
+type BlockMessage struct {
+	Block Block
+}
+
+// GetHeadersMessage 请求对方主链上的区块头。Locator 为发送方主链上从链尾到创世区块逐渐稀疏的区块哈希，
+// 对方从其中第一个位于自己主链上的区块之后开始，返回最多 maxHeadersPerMessage 个区块头，到 Stop 为止（为空时不限）
+type GetHeadersMessage struct {
+	Locator []string
+	Stop    string
+}
+
+// HeadersMessage 主链上连续的区块头，按高度从低到高排列，每个区块头为 MarshalBinary 的定长编码
+type HeadersMessage struct {
+	Headers [][]byte
+}
+
+// GetBlocksMessage 按哈希请求最多 maxBlocksPerRequest 个区块，对方在一条 blocks 中返回
+type GetBlocksMessage struct {
+	Hashes []string
+}
+
+// BlocksMessage 响应 getblocks，对方没有的区块不包含在内
+type BlocksMessage struct {
+	Blocks []Block
+}
+
+// RejectMessage 拒绝一条无法处理的消息。Rejected 为被拒绝的消息命令，帧无法解析时为空
+type RejectMessage struct {
+	Rejected string
+	Code     string
+	Reason   string
+}
+
+func (VersionMessage) Command() string    { return CommandVersion }
+func (VerackMessage) Command() string     { return CommandVerack }
+func (PingMessage) Command() string       { return CommandPing }
+func (PongMessage) Command() string       { return CommandPong }
+func (GetAddrMessage) Command() string    { return CommandGetAddr }
+func (AddrMessage) Command() string       { return CommandAddr }
+func (InvMessage) Command() string        { return CommandInv }
+func (GetDataMessage) Command() string    { return CommandGetData }
+func (NotFoundMessage) Command() string   { return CommandNotFound }
+func (TxMessage) Command() string         { return CommandTx }
+func (BlockMessage) Command() string      { return CommandBlock }
+func (GetHeadersMessage) Command() string { return CommandGetHeaders }
+func (HeadersMessage) Command() string    { return CommandHeaders }
+func (GetBlocksMessage) Command() string  { return CommandGetBlocks }
+func (BlocksMessage) Command() string     { return CommandBlocks }
+func (RejectMessage) Command() string     { return CommandReject }
+
+func (r RejectMessage) Error() string {
+	return fmt.Sprintf("对方拒绝 %q 消息 (%s): %s", r.Rejected, r.Code, r.Reason)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每个挖矿协程每尝试这么多个 Nonce 检查一次是否需要停止
+const miningCheckInterval = 1 << 12
+
+// MiningStats 记录一次挖矿的统计信息
+type MiningStats struct {
+	Workers  int           // 并行的挖矿协程数
+	Hashes   uint64        // 尝试的哈希次数
+	Duration time.Duration // 挖矿耗时
+	Rolls    int           // Nonce 空间耗尽后滚动时间戳的次数
+}
+
+// Hashrate 返回每秒的哈希次数
+func (s MiningStats) Hashrate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+func (s MiningStats) String() string {
+	return fmt.Sprintf("%d 个协程, %d 次哈希, 耗时 %v, 算力 %.2f H/s", s.Workers, s.Hashes, s.Duration.Round(time.Millisecond), s.Hashrate())
+}
+
+// Mine 将 Nonce 空间平均分给 workers 个协程并行搜索满足难度目标的区块哈希。
+// 所有协程都搜索完自己的区间仍未找到时，时间戳加一后重新搜索。
+// ctx 被取消（例如链尾已变化）时停止挖矿并返回 ctx.Err()
+func (b *Block) Mine(ctx context.Context, workers int) (MiningStats, error) {
+	return b.mine(ctx, workers, math.MaxUint64)
+}
+
+func (b *Block) mine(ctx context.Context, workers int, maxNonce uint64) (MiningStats, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	stats := MiningStats{Workers: workers}
+	start := time.Now()
+
+	target := make([]byte, sha256.Size)
+	b.Header.Target().FillBytes(target)
+
+	for {
+		data, err := b.Header.MarshalBinary()
+		if err != nil {
+			stats.Duration = time.Since(start)
+			return stats, fmt.Errorf("区块头编码失败: %w", err)
+		}
+
+		nonce, hash, found, hashes := searchNonces(ctx, data, target, workers, maxNonce)
+		stats.Hashes += hashes
+		if found {
+			b.Header.Nonce = nonce
+			b.Hash = hex.EncodeToString(hash)
+			stats.Duration = time.Since(start)
+			return stats, nil
+		}
+		if err := ctx.Err(); err != nil {
+			stats.Duration = time.Since(start)
+			return stats, err
+		}
+
+		// Nonce 空间已耗尽，滚动时间戳后继续
+		b.Header.Timestamp++
+		stats.Rolls++
+	}
+}
+
+// searchNonces 将 [0, maxNonce] 划分为 workers 个连续区间并行搜索，
+// 任一协程找到结果或 ctx 取消时所有协程停止
+func searchNonces(ctx context.Context, data, target []byte, workers int, maxNonce uint64) (uint64, []byte, bool, uint64) {
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		stop     atomic.Bool
+		hashes   atomic.Uint64
+		nonce    uint64
+		solution []byte
+	)
+
+	size := maxNonce/uint64(workers) + 1 // workers 为 1 时不使用该值，不会溢出
+	for w := 0; w < workers; w++ {
+		first, last := uint64(0), maxNonce
+		if workers > 1 {
+			first = uint64(w) * size
+			if first > maxNonce {
+				break
+			}
+			if w < workers-1 && first+size-1 < maxNonce {
+				last = first + size - 1
+			}
+		}
+
+		wg.Add(1)
+		go func(first, last uint64) {
+			defer wg.Done()
+			buf := append([]byte(nil), data...)
+			var count uint64
+			defer func() { hashes.Add(count) }()
+
+			for n := first; ; n++ {
+				if count%miningCheckInterval == 0 && (stop.Load() || ctx.Err() != nil) {
+					return
+				}
+				binary.BigEndian.PutUint64(buf[nonceOffset:], n)
+				hash := sha256.Sum256(buf)
+				count++
+				if hashMeetsTarget(hash[:], target) {
+					once.Do(func() {
+						nonce, solution = n, hash[:]
+						stop.Store(true)
+					})
+					return
+				}
+				if n == last {
+					return
+				}
+			}
+		}(first, last)
+	}
+	wg.Wait()
+
+	return nonce, solution, solution != nil, hashes.Load()
+}
+
+// mineBlock 基于当前链尾打包交易池中的交易并使用全部 CPU 核心挖矿。
+// 挖矿期间不持有 node.mu，链尾变化或 parent 被取消时返回 context.Canceled
+func (node *Node) mineBlock(parent context.Context, miner, filePath string) (Block, MiningStats, error) {
+	node.mu.Lock()
+	if node.miningCancel != nil {
+		node.mu.Unlock()
+		return Block{}, MiningStats{}, fmt.Errorf("已有挖矿任务在进行")
+	}
+	block := node.Blockchain.PrepareBlock(node.Blockchain.GetTransactionsForBlock(), miner, node.PublicKeys)
+	ctx, cancel := context.WithCancel(parent)
+	node.miningCancel = cancel
+	node.mu.Unlock()
+	defer cancel()
+
+	stats, err := block.Mine(ctx, runtime.GOMAXPROCS(0))
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.miningCancel = nil
+	if err != nil {
+		return block, stats, err
+	}
+	if err := node.Blockchain.AddBlock(block, filePath); err != nil {
+		return block, stats, err
+	}
+	node.Blockchain.ClearTransactionPool(block.Transactions)
+	node.connectBalances([]Block{block})
+	return block, stats, nil
+}
+
+// tipChanged 在链尾变化后调用，取消基于旧链尾的挖矿。调用方需持有 node.mu
+func (node *Node) tipChanged() {
+	if node.miningCancel != nil {
+		node.miningCancel()
+		node.miningCancel = nil
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMineFindsValidBlock(t *testing.T) {
+	block := newBlockTemplate(1, zeroHash, []Transaction{}, "Alice", testParams.InitialSubsidy, 0x1f0fffff)
+	stats, err := block.Mine(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("挖矿失败: %v", err)
+	}
+	if !block.HasValidProofOfWork() {
+		t.Errorf("挖出的区块哈希 %s 不满足难度目标", block.Hash)
+	}
+	if stats.Workers != 4 || stats.Hashes == 0 {
+		t.Errorf("挖矿统计不正确: %+v", stats)
+	}
+}
+
+func TestMineStopsWhenCanceled(t *testing.T) {
+	// 难度目标 1，几乎不可能找到结果
+	block := newBlockTemplate(1, zeroHash, []Transaction{}, "Alice", testParams.InitialSubsidy, 0x01010000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := block.Mine(ctx, 2)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("期望挖矿因超时取消, 实际: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后挖矿未停止")
+	}
+}
+
+func TestMineRollsTimestampWhenNonceSpaceExhausted(t *testing.T) {
+	block := newBlockTemplate(1, zeroHash, []Transaction{}, "Alice", testParams.InitialSubsidy, 0x1f0fffff)
+	start := block.Header.Timestamp
+
+	// 每轮只有 2 个 Nonce，平均需要滚动多次时间戳才能找到结果
+	stats, err := block.mine(context.Background(), 3, 1)
+	if err != nil {
+		t.Fatalf("挖矿失败: %v", err)
+	}
+	if !block.HasValidProofOfWork() || block.Header.Nonce > 1 {
+		t.Errorf("挖出的区块不正确: Nonce=%d, 哈希=%s", block.Header.Nonce, block.Hash)
+	}
+	if block.Header.Timestamp != start+int64(stats.Rolls) {
+		t.Errorf("时间戳应滚动 %d 次, 实际从 %d 变为 %d", stats.Rolls, start, block.Header.Timestamp)
+	}
+}
@@ -1,98 +1,127 @@
 package main
 
 import (
-	"bufio"
-	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"gamechain/wire"
 	"net"
-	"time"
 )
 
-const (
-	RequestTypeSync           = "sync"
-	RequestTypeNewBlock       = "new_block"
-	RequestTypeNewTransaction = "new_transaction"
-	RequestTypeUpdateBalance  = "update_balance"
-)
+var errProtocolVersion = errors.New("不支持的协议版本")
 
-// 广播消息
-func (node *Node) broadcast(requestType string, data map[string]interface{}) {
-	data["type"] = requestType
-	for _, peer := range node.PeerNodes {
-		if err := sendRequestToPeer(peer, data); err != nil {
-			fmt.Printf("广播到节点 %s 失败: %v\n", peer, err)
-		} else {
-			fmt.Printf("广播成功到节点 %s\n", peer)
-		}
-	}
+// writeMessage 将消息按本网络的帧格式写入连接
+func (node *Node) writeMessage(conn net.Conn, msg Message) error {
+	return wire.Encode(conn, node.Blockchain.Params.Magic(), protocolVersion, msg.Command(), msg)
 }
 
-// 发送请求到节点
-func sendRequestToPeer(peer string, request map[string]interface{}) error {
-	conn, err := net.Dial("tcp", peer)
+// readMessage 从连接读取一条本网络、当前协议版本且负载不超过 maxSize 字节的消息
+func (node *Node) readMessage(conn net.Conn, maxSize uint32) (wire.Message, error) {
+	msg, err := wire.ReadMessageLimit(conn, node.Blockchain.Params.Magic(), maxSize)
 	if err != nil {
-		return err
+		return wire.Message{}, err
 	}
-	defer conn.Close()
-
-	data, _ := json.Marshal(request)
-	_, err = conn.Write(append(data, '\n'))
-	return err
+	if msg.Version != protocolVersion {
+		return wire.Message{}, fmt.Errorf("%w: %d", errProtocolVersion, msg.Version)
+	}
+	return msg, nil
 }
 
-// 区块链同步逻辑
-func (node *Node) SyncBlockchain() {
-	fmt.Println("开始同步区块链...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	done := make(chan bool)
-	go func() {
-		for _, peer := range node.PeerNodes {
-			conn, err := connectWithTimeout(peer, 5*time.Second)
-			if err != nil {
-				fmt.Printf("无法连接到节点 %s: %v\n", peer, err)
-				continue
-			}
-			defer conn.Close()
-
-			request := map[string]interface{}{
-				"type": "sync",
-			}
-			jsonData, _ := json.Marshal(request)
-			conn.Write(jsonData)
-
-			reader := bufio.NewReader(conn)
-			response, err := reader.ReadString('\n')
-			if err != nil {
-				fmt.Printf("从节点 %s 接收数据失败: %v\n", peer, err)
-				continue
-			}
-
-			var receivedChain Blockchain
-			err = json.Unmarshal([]byte(response), &receivedChain)
-			if err != nil {
-				fmt.Printf("解析区块链数据失败: %v\n", err)
-				continue
-			}
-
-			if len(receivedChain.Blocks) > len(node.Blockchain.Blocks) {
-				node.Blockchain = &receivedChain
-				SaveBlockchain(blockchainFile, node.Blockchain)
-				fmt.Printf("已从节点 %s 同步到更长的链\n", peer)
-			} else {
-				fmt.Printf("节点 %s 的链较短，无需更新\n", peer)
+// handleMessage 处理会话中收到的消息，负载无法解析和未知命令都回复 reject
+func (node *Node) handleMessage(p *Peer, msg wire.Message) {
+	switch msg.Command {
+	case CommandPing:
+		var ping PingMessage
+		if node.decodeMessage(p, msg, &ping) {
+			p.Send(PongMessage{Nonce: ping.Nonce})
+		}
+	case CommandPong:
+		var pong PongMessage
+		if node.decodeMessage(p, msg, &pong) {
+			p.handlePong(pong.Nonce)
+		}
+	case CommandGetAddr:
+		p.Send(AddrMessage{Addrs: node.AddrBook.Sample(maxAddrPerMessage, p.ListenAddr)})
+	case CommandAddr:
+		var addr AddrMessage
+		if node.decodeMessage(p, msg, &addr) {
+			node.handleAddr(p, addr)
+		}
+	case CommandInv:
+		var inv InvMessage
+		if node.decodeMessage(p, msg, &inv) {
+			node.handleInv(p, inv)
+		}
+	case CommandGetData:
+		var request GetDataMessage
+		if node.decodeMessage(p, msg, &request) {
+			node.handleGetData(p, request)
+		}
+	case CommandTx:
+		var tx TxMessage
+		if node.decodeMessage(p, msg, &tx) {
+			node.handleTx(p, tx.Transaction)
+		}
+	case CommandBlock:
+		var block BlockMessage
+		if node.decodeMessage(p, msg, &block) {
+			node.handleBlock(p, block.Block)
+		}
+	case CommandNotFound:
+		var notFound NotFoundMessage
+		if node.decodeMessage(p, msg, &notFound) {
+			for _, item := range notFound.Items {
+				node.received(item)
+				fmt.Printf("节点 %s 没有 %s\n", p.Addr, item)
 			}
 		}
-		done <- true
-	}()
+	case CommandGetHeaders:
+		var request GetHeadersMessage
+		if node.decodeMessage(p, msg, &request) {
+			node.handleGetHeaders(p, request)
+		}
+	case CommandHeaders:
+		var headers HeadersMessage
+		if node.decodeMessage(p, msg, &headers) {
+			node.handleHeaders(p, headers)
+		}
+	case CommandGetBlocks:
+		var request GetBlocksMessage
+		if node.decodeMessage(p, msg, &request) {
+			node.handleGetBlocks(p, request)
+		}
+	case CommandBlocks:
+		var blocks BlocksMessage
+		if node.decodeMessage(p, msg, &blocks) {
+			node.handleBlocks(p, blocks)
+		}
+	case CommandReject:
+		var reject RejectMessage
+		if node.decodeMessage(p, msg, &reject) {
+			fmt.Printf("节点 %s: %v\n", p.Addr, reject)
+		}
+	case CommandVersion, CommandVerack:
+		p.Send(RejectMessage{Rejected: msg.Command, Code: RejectHandshake, Reason: "握手已完成"})
+	default:
+		fmt.Printf("节点 %s 发送了未知消息命令: %s\n", p.Addr, msg.Command)
+		p.Send(RejectMessage{Rejected: msg.Command, Code: RejectUnknown, Reason: "未知消息命令"})
+	}
+}
+
+// decodeMessage 解析消息负载，失败时回复 reject、增加对方的惩罚分并返回 false
+func (node *Node) decodeMessage(p *Peer, msg wire.Message, v any) bool {
+	if err := msg.Decode(v); err != nil {
+		fmt.Printf("节点 %s: %v\n", p.Addr, err)
+		p.Send(RejectMessage{Rejected: msg.Command, Code: RejectMalformed, Reason: err.Error()})
+		node.misbehaving(p, 10, err.Error())
+		return false
+	}
+	return true
+}
 
-	select {
-	case <-ctx.Done():
-		fmt.Println("同步操作超时")
-	case <-done:
-		fmt.Println("同步完成")
+// requestBlock 向指定节点请求某个区块，对方返回的区块按新区块处理
+func (node *Node) requestBlock(addr, hash string) {
+	p := node.peer(addr)
+	if p == nil || !p.Send(GetDataMessage{Items: []InvVector{{InvTypeBlock, hash}}}) {
+		fmt.Printf("与节点 %s 没有会话，无法请求区块 %s\n", addr, hash)
 	}
 }
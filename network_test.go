@@ -0,0 +1,502 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"gamechain/account"
+	"gamechain/coin"
+	"gamechain/wire"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestNode 创建使用内存地址簿的测试节点
+func newTestNode(bc *Blockchain, publicKeys map[string]*ecdsa.PublicKey) *Node {
+	return &Node{
+		Blockchain:     bc,
+		PublicKeys:     publicKeys,
+		AddrBook:       NewAddrBook(""),
+		BalanceManager: account.NewBalanceManager(),
+		Orphans:        NewOrphanPool(maxOrphanBlocks, orphanBlockExpiry),
+		FutureTxs:      NewFutureTxPool(),
+	}
+}
+
+// listenTestNode 在本地随机端口上为节点接受连接，测试结束时关闭监听和全部会话
+func listenTestNode(t *testing.T, node *Node) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Address = listener.Addr().String()
+	t.Cleanup(func() {
+		listener.Close()
+		for _, p := range node.Peers() {
+			p.close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.HandleConnection(conn)
+		}
+	}()
+	return node.Address
+}
+
+// waitFor 等待条件成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+	}
+}
+
+// dialRaw 连接节点并完成握手，但不运行会话，由测试直接读写连接。对方会话开始时发送的 ping 已被读取
+func dialRaw(t *testing.T, node *Node, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := newPeer(node, conn, addr, false).handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := node.readMessage(conn, wire.MaxPayloadSize); err != nil || msg.Command != CommandPing {
+		t.Fatalf("会话开始时应收到 ping: %+v, %v", msg, err)
+	}
+	return conn
+}
+
+// exchange 写入一帧并读取对方的回复
+func exchange(t *testing.T, node *Node, conn net.Conn, msg wire.Message) wire.Message {
+	t.Helper()
+	if err := wire.WriteMessage(conn, node.Blockchain.Params.Magic(), msg); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := node.readMessage(conn, wire.MaxPayloadSize)
+	if err != nil {
+		t.Fatalf("读取回复失败: %v", err)
+	}
+	return reply
+}
+
+func TestHandshakeEstablishesSessions(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	server := newTestNode(bc, publicKeys)
+	addr := listenTestNode(t, server)
+	client := newTestNode(bc, publicKeys)
+	listenTestNode(t, client)
+
+	if err := client.connectPeer(addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "服务端登记入站会话", func() bool { return len(server.Peers()) == 1 })
+	if p := server.Peers()[0]; p.Addr == client.Address || p.ListenAddr != client.Address || !p.Inbound || p.UserAgent != userAgent {
+		t.Errorf("入站会话应以连接的对端地址标识并记录对方声明的监听地址: %+v", p)
+	}
+	p := client.peer(addr)
+	if p == nil || p.Inbound {
+		t.Fatalf("客户端应登记出站会话: %+v", client.Peers())
+	}
+
+	// 会话开始时的 ping/pong 测得往返延迟
+	waitFor(t, "收到 pong", func() bool { _, latency := p.Stats(); return latency > 0 })
+
+	// 已有会话时重复连接被拒绝
+	if err := client.connectPeer(addr); err == nil {
+		t.Error("与同一节点的第二个会话应被拒绝")
+	}
+	if len(client.Peers()) != 1 {
+		t.Errorf("客户端应只保留一个会话: %d", len(client.Peers()))
+	}
+}
+
+func TestInboundClaimDoesNotDisplaceOutbound(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	server := newTestNode(bc, publicKeys)
+	addr := listenTestNode(t, server)
+	node := newTestNode(bc, publicKeys)
+	nodeAddr := listenTestNode(t, node)
+
+	// 攻击者在 version 中冒用 server 的监听地址
+	attack := func() {
+		t.Helper()
+		attacker := newTestNode(bc, publicKeys)
+		attacker.Address = addr
+		if err := attacker.connectPeer(nodeAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attack()
+	waitFor(t, "登记攻击者的入站会话", func() bool { return len(node.Peers()) == 1 })
+
+	// 冒用的地址不阻止向真正的 server 拨号，之后的入站会话也不替换出站会话
+	if err := node.connectPeer(addr); err != nil {
+		t.Fatalf("冒用监听地址的入站会话不应阻止拨号: %v", err)
+	}
+	attack()
+	waitFor(t, "登记第二个入站会话", func() bool { return len(node.Peers()) == 3 })
+	if p := node.peer(addr); p == nil || p.Inbound || p.nonce != server.localNonce() {
+		t.Fatalf("与 server 的出站会话应保留: %+v", p)
+	}
+}
+
+func TestSimultaneousConnectKeepsOneSession(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	a := newTestNode(bc, publicKeys)
+	addrA := listenTestNode(t, a)
+	b := newTestNode(bc, publicKeys)
+	addrB := listenTestNode(t, b)
+	if err := a.connectPeer(addrB); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "b 登记入站会话", func() bool { return len(b.Peers()) == 1 })
+	b.connectPeer(addrA)
+
+	// 双方最终只保留标识较小的节点发起的会话
+	initiator, other := a, b
+	if b.localNonce() < a.localNonce() {
+		initiator, other = b, a
+	}
+	waitFor(t, "只保留一个会话", func() bool {
+		out, in := initiator.Peers(), other.Peers()
+		return len(out) == 1 && !out[0].Inbound && len(in) == 1 && in[0].Inbound
+	})
+}
+
+func TestHandshakeRejectsForeignGenesisAndSelf(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	params := *testParams
+	params.GenesisAlloc = map[string]coin.Amount{"Alice": 1000 * coin.Coin}
+	foreign := newTestNode(&Blockchain{Params: &params, Blocks: []Block{newGenesisBlock(&params)}}, nil)
+	addr := listenTestNode(t, foreign)
+
+	node := newTestNode(bc, publicKeys)
+	self := listenTestNode(t, node)
+	if err := node.connectPeer(addr); err == nil {
+		t.Error("创世区块不同的节点应断开")
+	}
+	if err := node.connectPeer(self); err == nil {
+		t.Error("连接到自己应断开")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(node.Peers()) != 0 || len(foreign.Peers()) != 0 {
+		t.Errorf("不应建立任何会话: %d, %d", len(node.Peers()), len(foreign.Peers()))
+	}
+}
+
+func TestSessionRejectsInvalidMessages(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	server := newTestNode(bc, publicKeys)
+	addr := listenTestNode(t, server)
+	client := newTestNode(bc, publicKeys)
+	conn := dialRaw(t, client, addr)
+
+	// 帧无法解析时回复 reject 后断开会话，因此协议版本不同的情况放在最后
+	tests := []struct {
+		name string
+		msg  wire.Message
+		code string
+	}{
+		{"未知命令", wire.Message{Version: protocolVersion, Command: "mempool"}, RejectUnknown},
+		{"负载无法解析", wire.Message{Version: protocolVersion, Command: CommandTx, Payload: []byte("{")}, RejectMalformed},
+		{"重复握手", wire.Message{Version: protocolVersion, Command: CommandVerack, Payload: []byte("{}")}, RejectHandshake},
+		{"协议版本不同", wire.Message{Version: protocolVersion + 1, Command: CommandGetHeaders}, RejectVersion},
+	}
+	for _, test := range tests {
+		reply := exchange(t, client, conn, test.msg)
+		var reject RejectMessage
+		if reply.Command != CommandReject || reply.Decode(&reject) != nil || reject.Code != test.code {
+			t.Errorf("%s: 期望 %s 拒绝, 实际 %s %+v", test.name, test.code, reply.Command, reject)
+		}
+	}
+
+	// 未握手就发送其他消息
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if reply := exchange(t, client, raw, wire.Message{Version: protocolVersion, Command: CommandGetHeaders}); reply.Command != CommandVersion {
+		t.Fatalf("对方应首先发送 version, 实际 %s", reply.Command)
+	}
+	var reject RejectMessage
+	if reply, err := client.readMessage(raw, wire.MaxPayloadSize); err != nil || reply.Decode(&reject) != nil || reject.Code != RejectHandshake {
+		t.Errorf("未握手的消息应被拒绝: %+v, %v", reject, err)
+	}
+
+	// 握手期间的大消息在读取负载之前就被拒绝
+	raw, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	large := wire.Message{Version: protocolVersion, Command: CommandVersion, Payload: make([]byte, maxHandshakePayload+1)}
+	if reply := exchange(t, client, raw, large); reply.Command != CommandVersion {
+		t.Fatalf("对方应首先发送 version, 实际 %s", reply.Command)
+	}
+	if reply, err := client.readMessage(raw, wire.MaxPayloadSize); err != nil || reply.Decode(&reject) != nil || reject.Code != RejectMalformed {
+		t.Errorf("握手期间过大的消息应被拒绝: %+v, %v", reject, err)
+	}
+}
+
+func TestSessionServesBlocks(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	for i := 0; i < 2; i++ {
+		mineTestBlock(bc, nil, "Alice", publicKeys)
+	}
+	server := newTestNode(bc, publicKeys)
+	addr := listenTestNode(t, server)
+	client := newTestNode(bc, publicKeys)
+	conn := dialRaw(t, client, addr)
+	genesis := bc.Blocks[0]
+
+	request := func(msg Message) wire.Message {
+		t.Helper()
+		if err := client.writeMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := client.readMessage(conn, wire.MaxPayloadSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	var block BlockMessage
+	getGenesis := GetDataMessage{Items: []InvVector{{InvTypeBlock, genesis.Hash}}}
+	if reply := request(getGenesis); reply.Command != CommandBlock || reply.Decode(&block) != nil || block.Block.Hash != genesis.Hash {
+		t.Errorf("应返回创世区块, 实际 %s %+v", reply.Command, block)
+	}
+	var notFound NotFoundMessage
+	missing := []InvVector{{InvTypeBlock, "missing"}, {InvTypeTx, "missing"}}
+	if reply := request(GetDataMessage{Items: missing}); reply.Command != CommandNotFound || reply.Decode(&notFound) != nil || len(notFound.Items) != 2 {
+		t.Errorf("不存在的条目应在一条 notfound 中返回, 实际 %s %+v", reply.Command, notFound)
+	}
+	var msg HeadersMessage
+	reply := request(GetHeadersMessage{Locator: []string{"unknown", genesis.Hash}})
+	if reply.Command != CommandHeaders || reply.Decode(&msg) != nil {
+		t.Fatalf("应返回 headers, 实际 %s", reply.Command)
+	}
+	if headers, err := decodeHeaders(msg); err != nil || len(headers) != 2 || headers[1] != bc.Blocks[2].Header {
+		t.Errorf("应返回创世区块之后的全部区块头, 实际 %+v, %v", headers, err)
+	}
+	var blocks BlocksMessage
+	if reply := request(GetBlocksMessage{Hashes: []string{bc.Blocks[2].Hash, "missing"}}); reply.Command != CommandBlocks ||
+		reply.Decode(&blocks) != nil || len(blocks.Blocks) != 1 || blocks.Blocks[0].Hash != bc.Blocks[2].Hash {
+		t.Errorf("应只返回主链上有的区块, 实际 %s %+v", reply.Command, blocks)
+	}
+	if reply := request(PingMessage{Nonce: 7}); reply.Command != CommandPong {
+		t.Errorf("ping 应收到 pong, 实际 %s", reply.Command)
+	}
+}
+
+func TestDiscoveryConnectsThroughSeed(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	seed := newTestNode(bc, publicKeys)
+	seedAddr := listenTestNode(t, seed)
+	existing := newTestNode(bc, publicKeys)
+	listenTestNode(t, existing)
+	if err := existing.connectPeer(seedAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新节点只知道种子节点，通过 getaddr 得知已有节点后自动连接
+	newcomer := newTestNode(bc, publicKeys)
+	newcomer.SeedNodes = []string{seedAddr}
+	newcomer.MaxOutbound = 2
+	listenTestNode(t, newcomer)
+	newcomer.fillOutbound()
+	if newcomer.peer(seedAddr) == nil {
+		t.Fatal("地址簿为空时应连接种子节点")
+	}
+	waitFor(t, "从种子节点得知已有节点", func() bool {
+		return len(newcomer.AddrBook.Candidates(func(addr string) bool { return newcomer.peer(addr) != nil })) > 0
+	})
+	newcomer.fillOutbound()
+	if newcomer.peer(existing.Address) == nil || newcomer.outboundCount() != 2 {
+		t.Fatalf("应连接从种子节点得知的节点: %v", newcomer.Peers())
+	}
+
+	// 种子节点将新节点的地址转发给已有节点
+	waitFor(t, "已有节点得知新节点", func() bool {
+		total, _ := existing.AddrBook.Stats()
+		return total == 2
+	})
+}
+
+func TestGossipReachesLineTopology(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	chdirTemp(t)
+
+	// A - B - C 连成一条线，A 与 C 之间没有会话
+	nodes := make([]*Node, 3)
+	for i := range nodes {
+		nodes[i] = newTestNode(bc.withBlocks(append([]Block(nil), bc.Blocks...)), publicKeys)
+		listenTestNode(t, nodes[i])
+	}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	if err := b.connectPeer(a.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.connectPeer(b.Address); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 挖出的区块经 B 转发到 C
+	block := mineTestBlock(bc, nil, "Alice", publicKeys)
+	a.HandleNewBlock(block, "")
+	a.BroadcastBlock(block)
+	for _, node := range nodes {
+		waitFor(t, "区块传播到全部节点", func() bool {
+			node.mu.Lock()
+			defer node.mu.Unlock()
+			return node.Blockchain.FindBlock(block.Hash) == 1
+		})
+	}
+
+	// C 创建的交易经 B 转发到 A
+	tx := NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, coin.Coin, 1, privateKeys["Alice"])
+	if len(c.HandleNewTransaction(tx)) == 0 {
+		t.Fatal("交易应进入 C 的交易池")
+	}
+	c.BroadcastTransaction(tx)
+	waitFor(t, "交易传播到 A", func() bool { return a.haveInventory(InvVector{InvTypeTx, tx.ID()}) })
+
+	// 已经宣告过的条目不再向同一会话宣告
+	for _, node := range nodes {
+		if n := node.announce(InvVector{InvTypeBlock, block.Hash}); n != 0 {
+			t.Errorf("节点 %s 向 %d 个已知该区块的会话重复宣告", node.Address, n)
+		}
+	}
+}
+
+func TestInventorySetEvictsOldest(t *testing.T) {
+	set := newInventorySet(2)
+	items := []InvVector{{InvTypeTx, "a"}, {InvTypeTx, "b"}, {InvTypeBlock, "a"}}
+	for _, item := range items {
+		if !set.Add(item) {
+			t.Fatalf("%s 应为新条目", item)
+		}
+	}
+	if set.Add(items[2]) {
+		t.Error("已有条目不应再次加入")
+	}
+	if set.Has(items[0]) || !set.Has(items[1]) || !set.Has(items[2]) {
+		t.Error("超过容量时应淘汰最早加入的条目")
+	}
+}
+
+func TestMisbehavingPeerIsBanned(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	server := newTestNode(bc, publicKeys)
+	addr := listenTestNode(t, server)
+	client := newTestNode(bc, publicKeys)
+	listenTestNode(t, client)
+	conn := dialRaw(t, client, addr)
+
+	// 每条无法解析的负载增加 10 分，第 10 条后被封禁并断开
+	for i := 0; i < banThreshold/10; i++ {
+		if err := wire.WriteMessage(conn, bc.Params.Magic(), wire.Message{Version: protocolVersion, Command: CommandTx, Payload: []byte("{")}); err != nil {
+			break
+		}
+	}
+	waitFor(t, "封禁客户端", func() bool { return server.AddrBook.IsBanned(client.Address) && len(server.Peers()) == 0 })
+	if err := client.connectPeer(addr); err == nil {
+		t.Error("被封禁的节点不能重新建立会话")
+	}
+	// 封禁按 IP 记录，换一个监听地址也不能建立会话
+	other := newTestNode(bc, publicKeys)
+	listenTestNode(t, other)
+	if err := other.connectPeer(addr); err == nil {
+		t.Error("同一 IP 的其他节点不能建立会话")
+	}
+}
+
+func TestInvalidBlockAddsBanScore(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	chdirTemp(t)
+	server := newTestNode(bc.withBlocks(append([]Block(nil), bc.Blocks...)), publicKeys)
+	addr := listenTestNode(t, server)
+	client := newTestNode(bc, publicKeys)
+	conn := dialRaw(t, client, addr)
+	send := func(block Block) {
+		t.Helper()
+		if err := client.writeMessage(conn, BlockMessage{Block: block}); err != nil {
+			t.Fatal(err)
+		}
+		// 收到 pong 时区块已处理完毕
+		if reply := exchange(t, client, conn, wire.Message{Version: protocolVersion, Command: CommandPing, Payload: []byte(`{"Nonce":1}`)}); reply.Command != CommandPong {
+			t.Fatalf("应收到 pong, 实际 %s", reply.Command)
+		}
+	}
+
+	// 时间戳超前本地时间的区块可能只是时钟偏差，不惩罚
+	future := bc.newBlockOnTip(nil, "Alice", publicKeys)
+	future.Header.Timestamp = time.Now().Add(3 * time.Hour).Unix()
+	future.ProofOfWork()
+	send(future)
+
+	// 发送方公钥只登记在创建账户的节点上，本地没有该公钥不说明对方有过错
+	evePrivateKey, _ := account.GenerateKeyPair()
+	unknown := bc.newBlockOnTip([]Transaction{NewTransaction(testParams.ChainID, "Eve", "Bob", coin.Coin, 0, 1, evePrivateKey)}, "Alice", publicKeys)
+	unknown.ProofOfWork()
+	send(unknown)
+	send(unknown)
+
+	invalid := bc.newBlockOnTip(nil, "Alice", publicKeys)
+	invalid.Transactions[0].Amount++
+	invalid.ProofOfWork()
+	send(invalid)
+	if server.AddrBook.IsBanned(conn.LocalAddr().String()) {
+		t.Fatal("一个无效区块不应导致封禁")
+	}
+	client.writeMessage(conn, BlockMessage{Block: invalid})
+	waitFor(t, "发送两个无效区块后被封禁", func() bool {
+		return server.AddrBook.IsBanned(conn.LocalAddr().String()) && len(server.Peers()) == 0
+	})
+}
+
+func TestInboundListenAddrRelayedAfterDialBack(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	node := newTestNode(bc, publicKeys)
+	listenTestNode(t, node)
+	honest := newTestNode(bc, publicKeys)
+	honestAddr := listenTestNode(t, honest)
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+
+	conn, other := net.Pipe()
+	t.Cleanup(func() { conn.Close(); other.Close() })
+	p := newPeer(node, conn, "127.0.0.1:1", true)
+	for _, claim := range []struct {
+		addr  string
+		nonce uint64
+	}{
+		{unreachable.Addr().String(), 1},      // 无法连接
+		{honestAddr, honest.localNonce() + 1}, // 可以连接，但不是同一个节点
+	} {
+		p.ListenAddr, p.nonce = claim.addr, claim.nonce
+		node.verifyListenAddr(p)
+		if total, _ := node.AddrBook.Stats(); total != 0 {
+			t.Fatalf("回拨失败的地址 %s 不应记入地址簿", claim.addr)
+		}
+	}
+
+	p.ListenAddr, p.nonce = honestAddr, honest.localNonce()
+	node.verifyListenAddr(p)
+	if got := node.AddrBook.Sample(10, ""); len(got) != 1 || got[0].Addr != honestAddr {
+		t.Errorf("回拨成功的地址应记入地址簿: %+v", got)
+	}
+}
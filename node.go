@@ -1,14 +1,17 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"crypto/ecdsa"
-	"encoding/json"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"gamechain/account"
+	"gamechain/coin"
 	"net"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,75 +19,142 @@ type Node struct {
 	Address         string
 	Blockchain      *Blockchain
 	TransactionPool []Transaction
-	PeerNodes       []string
+	PeerNodes       []string  // 始终保持会话的节点（--peers）
+	SeedNodes       []string  // 地址簿中没有可用地址时连接的种子节点（--seeds）
+	MaxOutbound     int       // 目标出站会话数（--outbound）
+	AddrBook        *AddrBook // 已知节点地址及其惩罚分（peers.json）
 	PublicKeys      map[string]*ecdsa.PublicKey
 	BalanceManager  *account.BalanceManager
+	Orphans         *OrphanPool
+	FutureTxs       *FutureTxPool // nonce 超前、等待此前交易的交易
+
+	utxos          *UTXOSet           // UTXO 模式下主链的未花费输出索引，随区块接入和断开更新；账户模式下为 nil
+	mu             sync.Mutex         // 保护 Blockchain、utxos 及下面的挖矿状态
+	miningCancel   context.CancelFunc // 取消正在进行的挖矿，没有挖矿时为 nil
+	autoMiner      *backgroundMiner   // 后台持续挖矿，未启动时为 nil
+	reorgListeners []func(ReorgEvent) // 链重组事件的监听函数
+
+	peers     map[string]*Peer        // 已完成握手的会话，按 Peer.Addr 索引
+	nonce     uint64                  // 本节点的随机标识，握手时用于发现连接到自己
+	requested map[InvVector]time.Time // 已发出 getdata 尚未收到的条目及请求时间
+	peerMu    sync.Mutex              // 保护 peers、nonce 和 requested
+
+	download headerSync // 区块头优先同步的状态
 }
 
+// BroadcastTransaction 向所有会话宣告本地创建的交易，对方通过 getdata 获取交易内容
 func (node *Node) BroadcastTransaction(tx Transaction) {
-	node.broadcast(RequestTypeNewTransaction, map[string]interface{}{"transaction": tx})
+	count := node.announce(InvVector{InvTypeTx, tx.ID()})
+	fmt.Printf("已向 %d 个节点宣告交易 %s\n", count, tx.ID())
 }
 
+// BroadcastBlock 向所有会话宣告本地挖出的区块，对方通过 getdata 获取区块内容
 func (node *Node) BroadcastBlock(block Block) {
-	node.broadcast(RequestTypeNewBlock, map[string]interface{}{"block": block})
+	count := node.announce(InvVector{InvTypeBlock, block.Hash})
+	fmt.Printf("已向 %d 个节点宣告区块 #%d\n", count, block.Header.Index)
 }
 
+// HandleConnection 与发起连接的节点握手，成功后在当前协程运行会话直到连接断开
 func (node *Node) HandleConnection(conn net.Conn) {
-	defer conn.Close()
-	reader := bufio.NewReader(conn)
-	message, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Printf("读取消息错误: %v\n", err)
+	p := newPeer(node, conn, conn.RemoteAddr().String(), true)
+	if err := p.handshake(); err != nil {
+		fmt.Printf("与 %s 握手失败: %v\n", conn.RemoteAddr(), err)
+		conn.Close()
 		return
 	}
+	if node.addPeer(p) {
+		p.run()
+	}
+}
 
-	var request map[string]interface{}
-	if err := json.Unmarshal([]byte(message), &request); err != nil {
-		fmt.Printf("解析消息错误: %v\n", err)
-		return
+// HandleNewBlock 处理收到的区块，from 为发送方节点地址（本地挖出时为空）。区块本身不合法时返回校验错误
+func (node *Node) HandleNewBlock(block Block, from string) error {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.acceptBlock(block, from)
+}
+
+// acceptBlock 尝试将区块接入链中，调用方需持有 node.mu。
+// 区块本身不合法时返回校验错误；区块已存在、暂存为孤块、累计工作量不足或只有孤块池中的后代不合法时返回 nil
+func (node *Node) acceptBlock(block Block, from string) error {
+	bc := node.Blockchain
+	if bc.FindBlock(block.Hash) >= 0 || node.Orphans.Has(block.Hash) {
+		fmt.Printf("区块 #%d 已存在，已忽略\n", block.Header.Index)
+		return nil
 	}
 
-	switch request["type"] {
-	case RequestTypeNewTransaction:
-		node.handleTransaction(request)
-	case RequestTypeNewBlock:
-		node.handleBlock(request)
-	case RequestTypeSync:
-		fmt.Println("收到同步请求，返回区块链数据")
-		node.SendBlockchain(conn)
-	case RequestTypeUpdateBalance:
-		node.updateBalance(request)
-	default:
-		fmt.Printf("未知请求类型: %v\n", request["type"])
+	parentIndex := bc.FindBlock(block.Header.PreviousHash)
+	if parentIndex < 0 {
+		return node.handleOrphanBlock(block, from)
 	}
+
+	branches := node.Orphans.Branches(block)
+	node.Orphans.RemoveSubtree(block.Hash)
+
+	// 直接接在链尾且没有等待中的子孤块
+	if parentIndex == len(bc.Blocks)-1 && len(branches) == 1 && len(branches[0]) == 1 {
+		if err := bc.validateNextBlock(&block, node.PublicKeys); err != nil {
+			fmt.Printf("无效块，已忽略: %v\n", err)
+			return err
+		}
+		bc.Blocks = append(bc.Blocks, block)
+		bc.ClearTransactionPool(block.Transactions)
+		SaveBlockchain(blockchainFile, bc)
+		node.tipChanged()
+		node.connectBalances([]Block{block})
+		node.retryFutureTxs()
+		fmt.Printf("新块已接受: #%d\n", block.Header.Index)
+		return nil
+	}
+
+	// 区块连同孤块池中的后代组成若干候选分支，按累计工作量选出最优的一条。
+	// 每个分支都以该区块开始，校验失败的位置正是该区块时说明区块本身不合法
+	var best *Blockchain
+	for _, branch := range branches {
+		candidate := bc.withBlocks(append(append([]Block{}, bc.Blocks[:parentIndex+1]...), branch...))
+		if best != nil && !candidate.HasMoreWorkThan(best) {
+			continue
+		}
+		if err := candidate.ValidateChain(node.PublicKeys); err != nil {
+			fmt.Printf("候选分支校验失败，已忽略: %v\n", err)
+			var invalid *ChainValidationError
+			if errors.As(err, &invalid) && invalid.Index == parentIndex+1 {
+				return err
+			}
+			continue
+		}
+		best = candidate
+	}
+	if best == nil || !best.HasMoreWorkThan(bc) {
+		fmt.Printf("分叉块 #%d 的累计工作量不足，已忽略\n", block.Header.Index)
+		return nil
+	}
+	node.switchChain(best)
+	fmt.Printf("已切换到累计工作量更大的链，新链尾: #%d\n", best.Blocks[len(best.Blocks)-1].Header.Index)
+	return nil
 }
 
-func (node *Node) updateBalance(request map[string]interface{}) {
-	accountName, ok := request["account"].(string)
-	if !ok {
-		fmt.Println("账户名称解析失败")
-		return
+// handleOrphanBlock 暂存父区块未知的区块，并向发送方请求缺失的祖先区块。工作量证明无效时返回错误
+func (node *Node) handleOrphanBlock(block Block, from string) error {
+	if block.Header.Target().Cmp(CompactToBig(node.Blockchain.Params.PowLimitBits)) > 0 || !block.HasValidProofOfWork() {
+		fmt.Printf("孤块 #%d 的工作量证明无效，已忽略\n", block.Header.Index)
+		return fmt.Errorf("孤块 #%d 的工作量证明无效", block.Header.Index)
 	}
-	newBalance := request["newBalance"].(float64)
-	node.BalanceManager.SetBalance(accountName, newBalance)
-	if err := node.BalanceManager.SaveBalances(balancesFile); err != nil {
-		fmt.Printf("保存余额失败: %v\n", err)
+	node.Orphans.Add(block)
+	fmt.Printf("区块 #%d 的父区块未知，已放入孤块池 (共 %d 个)\n", block.Header.Index, node.Orphans.Len())
+
+	if from == "" {
+		go node.SyncBlockchain()
+		return nil
 	}
-	fmt.Printf("账户 %s 的余额已更新为 %.2f\n", accountName, newBalance)
+	go node.requestBlock(from, node.Orphans.MissingAncestor(block))
+	return nil
 }
 
-func (node *Node) HandleNewBlock(block Block) {
-	lastBlock := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
-	if block.Header.PreviousHash == lastBlock.Hash && block.Hash == block.CalculateHash() {
-		node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
-		node.Blockchain.ClearTransactionPool(block.Transactions)
-		SaveBlockchain(blockchainFile, node.Blockchain)
-		fmt.Printf("新块已接受: #%d\n", block.Header.Index)
-	} else if block.Header.Index > lastBlock.Header.Index {
-		fmt.Println("检测到更长的链，尝试同步...")
-		node.SyncBlockchain()
-	} else {
-		fmt.Println("无效块，已忽略")
+// connectOrphans 尝试接入父区块已在主链上的孤块子树，调用方需持有 node.mu
+func (node *Node) connectOrphans() {
+	for _, root := range node.Orphans.Roots(func(hash string) bool { return node.Blockchain.FindBlock(hash) >= 0 }) {
+		node.acceptBlock(root, "")
 	}
 }
 
@@ -96,6 +166,7 @@ func (node *Node) Start() {
 	}
 	defer listener.Close()
 	fmt.Printf("节点启动，监听地址: %s\n", node.Address)
+	go node.maintainPeers()
 
 	for {
 		conn, err := listener.Accept()
@@ -112,55 +183,64 @@ func connectWithTimeout(address string, timeout time.Duration) (net.Conn, error)
 	return d.Dial("tcp", address)
 }
 
-func (node *Node) SendBlockchain(conn net.Conn) {
-	data, _ := json.Marshal(node.Blockchain)
-	conn.Write(data)
-}
-
-func mapToStruct(data interface{}, target interface{}) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(jsonData, target)
-}
-
 func (node *Node) handleMine(args []string, blockchainFile string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "start":
+			node.handleMineStart(args[1:], blockchainFile)
+			return
+		case "stop":
+			node.handleMineStop()
+			return
+		case "status":
+			node.handleMineStatus()
+			return
+		}
+	}
 	if len(args) != 1 {
-		fmt.Println("用法: mine [miner_account]")
+		fmt.Println("用法: mine [miner_account] | mine start [account] [empty] | mine stop | mine status")
 		return
 	}
 	miner := args[0]
-	transactions := node.Blockchain.GetTransactionsForBlock()
-	if len(transactions) == 0 {
+	node.mu.Lock()
+	poolSize := len(node.Blockchain.GetTransactionsForBlock())
+	node.mu.Unlock()
+	if poolSize == 0 {
 		fmt.Println("没有交易可供打包，跳过挖矿")
 		return
 	}
 
-	// 打包交易并生成新区块
-	node.Blockchain.AddBlock(transactions, miner, node.PublicKeys, blockchainFile)
-
-	// 从交易池中移除已打包的交易
-	node.Blockchain.ClearTransactionPool(transactions)
+	// 打包交易并挖矿，挖矿期间链尾变化会取消本次挖矿
+	block, stats, err := node.mineBlock(context.Background(), miner, blockchainFile)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("链尾已变化，本次挖矿已取消")
+		return
+	}
+	if err != nil {
+		fmt.Printf("挖矿失败: %v\n", err)
+		return
+	}
+	fmt.Printf("挖矿统计: %s\n", stats)
 
 	// 广播新区块
-	node.BroadcastBlock(node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1])
-	fmt.Printf("新区块已生成并广播，矿工 %s 获得奖励 50.0\n", miner)
+	node.BroadcastBlock(block)
+	reward := block.Transactions[len(block.Transactions)-1].Amount
+	fmt.Printf("新区块已生成并广播，矿工 %s 获得奖励 %s（含手续费）\n", miner, reward)
 }
 
-// parseAmount 将字符串解析为浮点数，如果解析失败则返回 0，并打印错误信息
-func parseAmount(amountStr string) float64 {
-	amount, err := strconv.ParseFloat(amountStr, 64)
+// parseAmount 将字符串解析为金额，如果解析失败则返回 0，并打印错误信息
+func parseAmount(amountStr string) coin.Amount {
+	amount, err := coin.Parse(amountStr)
 	if err != nil {
-		fmt.Printf("无效金额: %s\n", amountStr)
+		fmt.Printf("无效金额: %v\n", err)
 		return 0
 	}
 	return amount
 }
 
-func (node *Node) handleTransactionCommand(args []string, privateKeys map[string]*ecdsa.PrivateKey, transactionPoolFile string, balanceManager *account.BalanceManager) {
-	if len(args) != 3 {
-		fmt.Println("用法: tx [sender] [receiver] [amount]")
+func (node *Node) handleTransactionCommand(args []string, privateKeys map[string]*ecdsa.PrivateKey, transactionPoolFile string) {
+	if len(args) != 3 && len(args) != 4 {
+		fmt.Println("用法: tx [sender] [receiver] [amount] [fee]")
 		return
 	}
 	sender, receiver, amountStr := args[0], args[1], args[2]
@@ -168,22 +248,114 @@ func (node *Node) handleTransactionCommand(args []string, privateKeys map[string
 	if amount <= 0 {
 		return
 	}
+	var fee coin.Amount
+	if len(args) == 4 {
+		var err error
+		if fee, err = coin.Parse(args[3]); err != nil || fee < 0 {
+			fmt.Printf("无效手续费: %s\n", args[3])
+			return
+		}
+	}
+
+	// 余额只在交易被打包进区块后变化，这里由交易池检查余额是否足够
+	node.mu.Lock()
+	var tx Transaction
+	if node.Blockchain.Params.UTXO {
+		var err error
+		if tx, err = node.Blockchain.NewUTXOTransfer(sender, []TxOutput{{Receiver: receiver, Amount: amount}}, fee, privateKeys[sender], node.PublicKeys); err != nil {
+			node.mu.Unlock()
+			fmt.Printf("[TX] 创建交易失败: %v\n", err)
+			return
+		}
+	} else {
+		tx = NewTransaction(node.Blockchain.Params.ChainID, sender, receiver, amount, fee, node.Blockchain.NextNonce(sender), privateKeys[sender])
+	}
+	added := node.Blockchain.AddTransactionToPool(tx, node.PublicKeys, transactionPoolFile)
+	node.mu.Unlock()
+	if added {
+		node.BroadcastTransaction(tx)
+		fmt.Printf("[TX] 交易已广播: %s -> %s (金额: %s, 手续费: %s, nonce: %d, ID: %s)\n", sender, receiver, amount, fee, tx.Nonce, tx.ID())
+	} else {
+		fmt.Println("[TX] 交易未能加入交易池")
+	}
+}
 
-	if !balanceManager.DeductBalance(sender, amount, balancesFile) {
-		fmt.Printf("[TX] 账户 %s 余额不足\n", sender)
+func (node *Node) handleBatchTransactionCommand(args []string, privateKeys map[string]*ecdsa.PrivateKey, transactionPoolFile string) {
+	if len(args) != 2 && len(args) != 3 {
+		fmt.Println("用法: tx_batch [sender] [file.csv] [fee]")
+		return
+	}
+	sender := args[0]
+	outputs, err := readBatchOutputs(args[1])
+	if err != nil {
+		fmt.Printf("[TX] 读取收款列表失败: %v\n", err)
 		return
 	}
+	var fee coin.Amount
+	if len(args) == 3 {
+		if fee, err = coin.Parse(args[2]); err != nil || fee < 0 {
+			fmt.Printf("无效手续费: %s\n", args[2])
+			return
+		}
+	}
 
-	tx := NewTransaction(sender, receiver, amount, privateKeys[sender])
-	if node.Blockchain.AddTransactionToPool(tx, node.PublicKeys, transactionPoolFile) {
-		balanceManager.AddBalance(receiver, amount, balancesFile)
+	// 一笔交易、一个签名向全部收款方付款；UTXO 模式下由 UTXO 交易的多个输出完成
+	node.mu.Lock()
+	var tx Transaction
+	if node.Blockchain.Params.UTXO {
+		tx, err = node.Blockchain.NewUTXOTransfer(sender, outputs, fee, privateKeys[sender], node.PublicKeys)
+	} else {
+		tx, err = NewBatchTransaction(node.Blockchain.Params.ChainID, sender, outputs, fee, node.Blockchain.NextNonce(sender), privateKeys[sender])
+	}
+	if err != nil {
+		node.mu.Unlock()
+		fmt.Printf("[TX] 创建交易失败: %v\n", err)
+		return
+	}
+	added := node.Blockchain.AddTransactionToPool(tx, node.PublicKeys, transactionPoolFile)
+	node.mu.Unlock()
+	if added {
 		node.BroadcastTransaction(tx)
-		fmt.Printf("[TX] 交易已广播: %s -> %s (金额: %.2f)\n", sender, receiver, amount)
+		cost, _ := tx.Cost()
+		fmt.Printf("[TX] 批量交易已广播: %s -> %d 个收款方 (合计: %s, 手续费: %s, ID: %s)\n", sender, len(outputs), cost-fee, fee, tx.ID())
 	} else {
 		fmt.Println("[TX] 交易未能加入交易池")
 	}
 }
 
+// readBatchOutputs 读取 CSV 收款列表，每行为 "收款方,金额"。以 # 开头的行为注释，
+// 首行为 receiver,amount 时视为表头
+func readBatchOutputs(filePath string) ([]TxOutput, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), "receiver") {
+		records = records[1:]
+	}
+
+	outputs := make([]TxOutput, 0, len(records))
+	for i, record := range records {
+		receiver := strings.TrimSpace(record[0])
+		amount, err := coin.Parse(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 条收款记录金额无效: %w", i+1, err)
+		}
+		outputs = append(outputs, TxOutput{Receiver: receiver, Amount: amount})
+	}
+	return outputs, nil
+}
+
 func (node *Node) handleBalanceCommand(args []string, balanceManager *account.BalanceManager) {
 	if len(args) != 1 {
 		fmt.Println("用法: balance [account]")
@@ -194,7 +366,93 @@ func (node *Node) handleBalanceCommand(args []string, balanceManager *account.Ba
 	if !exists {
 		fmt.Printf("账户 %s 不存在\n", account)
 	} else {
-		fmt.Printf("账户 %s 的余额: %.2f\n", account, balance)
+		fmt.Printf("账户 %s 的余额: %s\n", account, balance)
+	}
+}
+
+func (node *Node) handleUTXOsCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("用法: utxos [account]")
+		return
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.utxos == nil {
+		fmt.Println("当前网络未启用 UTXO 模式")
+		return
+	}
+	height := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1].Header.Index + 1
+	points, utxos := node.utxos.Unspent(args[0])
+	var total coin.Amount
+	fmt.Printf("账户 %s 的未花费输出:\n", args[0])
+	for i, utxo := range utxos {
+		status := "可花费"
+		if !node.utxos.Spendable(utxo, height) {
+			status = "未成熟"
+		}
+		fmt.Printf("  %s  金额: %s  高度: %d  %s\n", points[i], utxo.Amount, utxo.Height, status)
+		total += utxo.Amount
+	}
+	fmt.Printf("共 %d 个输出，总额 %s\n", len(utxos), total)
+}
+
+func (node *Node) handleNonceCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("用法: nonce [account]")
+		return
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	fmt.Printf("账户 %s 的下一笔交易 nonce: %d\n", args[0], node.Blockchain.NextNonce(args[0]))
+}
+
+// handlePeersCommand 列出已建立的会话及对方的高度和往返延迟
+func (node *Node) handlePeersCommand(args []string) {
+	total, banned := node.AddrBook.Stats()
+	fmt.Printf("地址簿: %d 个地址，%d 个 IP 被封禁\n", total, banned)
+	peers := node.Peers()
+	if len(peers) == 0 {
+		fmt.Println("没有已连接的节点")
+		return
+	}
+	fmt.Printf("已连接 %d 个节点:\n", len(peers))
+	for _, p := range peers {
+		height, latency := p.Stats()
+		direction := "出站"
+		if p.Inbound {
+			direction = "入站"
+			if p.ListenAddr != "" {
+				direction += fmt.Sprintf(" (声明监听 %s)", p.ListenAddr)
+			}
+		}
+		delay := "未测得"
+		if latency > 0 {
+			delay = fmt.Sprintf("%.1fms", float64(latency)/float64(time.Millisecond))
+		}
+		fmt.Printf("  %s  %s  高度: %d  延迟: %s  客户端: %s  已连接: %s\n",
+			p.Addr, direction, height, delay, p.UserAgent, time.Since(p.Connected).Round(time.Second))
+	}
+}
+
+func (node *Node) handleTxInfoCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("用法: tx_info [id]")
+		return
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	tx, index, found := node.Blockchain.FindTransaction(args[0])
+	if !found {
+		fmt.Printf("交易 %s 不存在\n", args[0])
+		return
+	}
+	fmt.Printf("交易 %s: %s -> %s (金额: %s, 手续费: %s, nonce: %d)\n", args[0], tx.Sender, tx.Receiver, tx.Amount, tx.Fee, tx.Nonce)
+	printInputsOutputs(&tx, "  ")
+	if index < 0 {
+		fmt.Println("状态: 在交易池中等待打包")
+	} else {
+		block := node.Blockchain.Blocks[index]
+		fmt.Printf("状态: 已打包于区块 #%d (%s)，确认数 %d\n", block.Header.Index, block.Hash, len(node.Blockchain.Blocks)-index)
 	}
 }
 
@@ -205,7 +463,7 @@ func (node *Node) handleCreateAccountCommand(args []string, accounts *[]account.
 	}
 	name := args[0]
 	account.CreateNewAccount(name, accounts, privateKeys, node.PublicKeys, accountsFile, encryptionKey)
-	balanceManager.SetBalance(name, 100.0) // 初始化账户余额
+	// 新账户的余额从 0 开始，之后由区块链中的交易推导，无需重建余额
 	fmt.Printf("账户 %s 已创建\n", name)
 }
 
@@ -217,60 +475,46 @@ func (node *Node) listAccounts(accounts *[]account.Account) {
 }
 
 func (node *Node) handleVerifyBalanceCommand(args []string, balanceManager *account.BalanceManager) {
-	// 参数检查，确保不需要输入账户名
 	if len(args) != 0 {
 		fmt.Println("用法: verify_balance (无需参数)")
 		return
 	}
 
-	fmt.Println("开始验证所有账户的余额...")
+	node.mu.Lock()
+	defer node.mu.Unlock()
 
-	// 获取所有账户的名称
-	allAccounts := balanceManager.GetAllAccounts()
-	if len(allAccounts) == 0 {
-		fmt.Println("没有找到任何账户")
-		return
+	// 以链尾区块头中的状态根为准，旧版本区块没有状态根时使用重放主链得到的结果
+	l := node.Blockchain.chainLedger()
+	tip := node.Blockchain.Blocks[len(node.Blockchain.Blocks)-1]
+	expected := l.stateRoot()
+	if tip.Header.HasStateRoot() {
+		expected = tip.Header.StateRoot
 	}
 
-	// 遍历每个账户进行验证
-	for _, accountName := range allAccounts {
-		// 根据区块链计算余额
-		calculatedBalance := node.Blockchain.ValidateBalance(accountName)
-
-		// 从余额管理器中获取当前余额
-		currentBalance, exists := balanceManager.GetBalance(accountName)
-		if !exists {
-			fmt.Printf("账户 %s 不存在于余额管理器中，跳过\n", accountName)
-			continue
-		}
+	// 余额缓存不记录 nonce，nonce 取自主链
+	cached := make(map[string]coin.Amount)
+	for _, name := range balanceManager.GetAllAccounts() {
+		cached[name], _ = balanceManager.GetBalance(name)
+	}
+	root := stateRoot(cached, l.nonces, l.initial)
+	fmt.Printf("链尾 #%d 的状态根: %s\n", tip.Header.Index, expected)
+	fmt.Printf("余额缓存的状态根: %s\n", root)
+	if root == expected {
+		fmt.Println("余额缓存与区块链状态一致")
+		return
+	}
 
-		// 检查余额是否一致
-		if calculatedBalance == currentBalance {
-			fmt.Printf("账户 %s 的余额验证通过: %.2f\n", accountName, currentBalance)
-		} else {
-			// 更新余额
-			fmt.Printf("账户 %s 的余额不一致: 当前余额=%.2f, 计算余额=%.2f\n", accountName, currentBalance, calculatedBalance)
-			balanceManager.SetBalance(accountName, calculatedBalance)
-
-			// 异步保存余额
-			go func(account string) {
-				if err := balanceManager.SaveBalances(balancesFile); err != nil {
-					fmt.Printf("保存账户 %s 的余额失败: %v\n", account, err)
-				} else {
-					fmt.Printf("账户 %s 的余额已成功保存到文件\n", account)
-				}
-			}(accountName)
-
-			// 广播更新余额
-			node.broadcast(RequestTypeUpdateBalance, map[string]interface{}{
-				"account":    accountName,
-				"newBalance": calculatedBalance,
-			})
-			fmt.Printf("账户 %s 的余额已更新为 %.2f，并尝试广播\n", accountName, calculatedBalance)
+	for name, balance := range l.balances {
+		if name != account.SystemAccount && cached[name] != balance {
+			fmt.Printf("账户 %s 的余额不一致: 当前余额=%s, 计算余额=%s\n", name, cached[name], balance)
 		}
 	}
-
-	fmt.Println("所有账户余额验证完成")
+	// 余额文件只是区块链状态的缓存，不一致时直接从区块链重建
+	if _, err := node.rebuildBalances(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("余额缓存已从区块链重建")
 }
 
 func (node *Node) exitNode(balanceManager *account.BalanceManager) {
@@ -278,5 +522,8 @@ func (node *Node) exitNode(balanceManager *account.BalanceManager) {
 	if err := balanceManager.SaveBalances(balancesFile); err != nil {
 		fmt.Printf("保存余额失败: %v\n", err)
 	}
+	if err := node.AddrBook.Save(); err != nil {
+		fmt.Println(err)
+	}
 	os.Exit(0)
 }
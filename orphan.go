@@ -0,0 +1,178 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxOrphanBlocks   = 100              // 孤块池最多保存的区块数
+	orphanBlockExpiry = 10 * time.Minute // 孤块在池中的最长保留时间
+)
+
+type orphanBlock struct {
+	block    Block
+	received time.Time
+}
+
+// OrphanPool 暂存父区块未知的区块，按 PreviousHash 索引
+type OrphanPool struct {
+	mu       sync.Mutex
+	byParent map[string][]string     // PreviousHash -> 子区块哈希列表
+	byHash   map[string]*orphanBlock // 区块哈希 -> 孤块
+	maxSize  int
+	maxAge   time.Duration
+}
+
+// NewOrphanPool 创建具有容量和时间限制的孤块池
+func NewOrphanPool(maxSize int, maxAge time.Duration) *OrphanPool {
+	return &OrphanPool{
+		byParent: make(map[string][]string),
+		byHash:   make(map[string]*orphanBlock),
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+	}
+}
+
+// Add 将区块加入孤块池，已存在时返回 false。池满时淘汰最早收到的孤块
+func (p *OrphanPool) Add(block Block) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.byHash[block.Hash]; exists {
+		return false
+	}
+	p.expire(time.Now())
+	for len(p.byHash) >= p.maxSize {
+		p.removeOldest()
+	}
+
+	p.byHash[block.Hash] = &orphanBlock{block: block, received: time.Now()}
+	p.byParent[block.Header.PreviousHash] = append(p.byParent[block.Header.PreviousHash], block.Hash)
+	return true
+}
+
+// Has 判断区块是否在孤块池中
+func (p *OrphanPool) Has(hash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, exists := p.byHash[hash]
+	return exists
+}
+
+// Len 返回孤块池中的区块数
+func (p *OrphanPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.byHash)
+}
+
+// MissingAncestor 沿孤块的父区块向上查找，返回第一个不在池中的祖先区块哈希
+func (p *OrphanPool) MissingAncestor(block Block) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	parent := block.Header.PreviousHash
+	for {
+		orphan, exists := p.byHash[parent]
+		if !exists {
+			return parent
+		}
+		parent = orphan.block.Header.PreviousHash
+	}
+}
+
+// Branches 返回以 root 为起点、沿池中子孤块延伸到每个叶子的所有分支
+func (p *OrphanPool) Branches(root Block) [][]Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.branches([]Block{root})
+}
+
+func (p *OrphanPool) branches(path []Block) [][]Block {
+	children := p.byParent[path[len(path)-1].Hash]
+	if len(children) == 0 {
+		return [][]Block{path}
+	}
+	var result [][]Block
+	for _, hash := range children {
+		next := append(append([]Block{}, path...), p.byHash[hash].block)
+		result = append(result, p.branches(next)...)
+	}
+	return result
+}
+
+// Roots 返回父区块满足 known 的所有孤块，即已经可以尝试接入的子树根
+func (p *OrphanPool) Roots(known func(hash string) bool) []Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var roots []Block
+	for parent, children := range p.byParent {
+		if !known(parent) {
+			continue
+		}
+		for _, hash := range children {
+			roots = append(roots, p.byHash[hash].block)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Header.Index < roots[j].Header.Index })
+	return roots
+}
+
+// RemoveSubtree 移除以 hash 为根的孤块及其所有后代
+func (p *OrphanPool) RemoveSubtree(hash string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeSubtree(hash)
+}
+
+func (p *OrphanPool) removeSubtree(hash string) {
+	for _, child := range p.byParent[hash] {
+		p.removeSubtree(child)
+	}
+	delete(p.byParent, hash)
+	if orphan, exists := p.byHash[hash]; exists {
+		p.unlink(orphan.block)
+	}
+}
+
+// unlink 从两个索引中删除单个孤块
+func (p *OrphanPool) unlink(block Block) {
+	delete(p.byHash, block.Hash)
+	siblings := p.byParent[block.Header.PreviousHash]
+	for i, hash := range siblings {
+		if hash == block.Hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, block.Header.PreviousHash)
+	} else {
+		p.byParent[block.Header.PreviousHash] = siblings
+	}
+}
+
+// expire 删除超过保留时间的孤块
+func (p *OrphanPool) expire(now time.Time) {
+	for _, orphan := range p.byHash {
+		if now.Sub(orphan.received) > p.maxAge {
+			p.unlink(orphan.block)
+		}
+	}
+}
+
+// removeOldest 删除最早收到的孤块
+func (p *OrphanPool) removeOldest() {
+	var oldest *orphanBlock
+	for _, orphan := range p.byHash {
+		if oldest == nil || orphan.received.Before(oldest.received) {
+			oldest = orphan
+		}
+	}
+	if oldest != nil {
+		p.unlink(oldest.block)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testOrphan(hash, parent string, index int) Block {
+	return Block{Header: BlockHeader{Index: index, PreviousHash: parent}, Hash: hash}
+}
+
+func TestOrphanPoolBranchesAndMissingAncestor(t *testing.T) {
+	pool := NewOrphanPool(10, time.Minute)
+	pool.Add(testOrphan("b2", "b1", 2))
+	pool.Add(testOrphan("b3", "b2", 3))
+	pool.Add(testOrphan("c3", "b2", 3))
+
+	if missing := pool.MissingAncestor(testOrphan("b4", "b3", 4)); missing != "b1" {
+		t.Errorf("期望缺失祖先 b1, 实际 %s", missing)
+	}
+
+	branches := pool.Branches(testOrphan("b1", "b0", 1))
+	if len(branches) != 2 {
+		t.Fatalf("期望 2 条分支, 实际 %d 条", len(branches))
+	}
+	for _, branch := range branches {
+		if len(branch) != 3 || branch[0].Hash != "b1" || branch[1].Hash != "b2" {
+			t.Errorf("分支结构不正确: %+v", branch)
+		}
+	}
+
+	pool.RemoveSubtree("b2")
+	if pool.Len() != 0 {
+		t.Errorf("移除子树后孤块池应为空, 实际 %d 个", pool.Len())
+	}
+}
+
+func TestOrphanPoolLimits(t *testing.T) {
+	pool := NewOrphanPool(2, time.Minute)
+	pool.Add(testOrphan("a", "x", 1))
+	time.Sleep(time.Millisecond)
+	pool.Add(testOrphan("b", "y", 1))
+	pool.Add(testOrphan("c", "z", 1))
+
+	if pool.Has("a") || !pool.Has("b") || !pool.Has("c") {
+		t.Error("孤块池已满时应淘汰最早收到的孤块")
+	}
+
+	pool = NewOrphanPool(10, time.Millisecond)
+	pool.Add(testOrphan("a", "x", 1))
+	time.Sleep(5 * time.Millisecond)
+	pool.Add(testOrphan("b", "y", 1))
+	if pool.Has("a") {
+		t.Error("过期的孤块应被移除")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"gamechain/coin"
+)
+
+// ChainParams 定义一个网络的共识参数
+type ChainParams struct {
+	Name             string
+	ChainID          string      // 链 ID，写入交易签名数据，使签名不能在其他网络上重放
+	InitialBits      uint32      // 创世区块及首个调整周期的难度目标（紧凑格式）
+	PowLimitBits     uint32      // 允许的最大难度目标，即最低难度（紧凑格式）
+	RetargetInterval int         // 每隔多少个区块调整一次难度
+	TargetBlockTime  int64       // 期望的出块间隔（秒）
+	RetargetFactor   int64       // 单次调整中目标值最多缩放的倍数
+	InitialSubsidy   coin.Amount // 高度 1 起的出块奖励
+	HalvingInterval  int         // 每隔多少个区块出块奖励减半
+	MaxSupply        coin.Amount // 出块奖励累计发行量的上限
+	CoinbaseMaturity int         // 奖励交易需要经过多少个区块才能被花费
+	GenesisTimestamp int64       // 创世区块的时间戳，固定后各节点生成相同的创世区块
+	UTXO             bool        // 是否使用 UTXO 账本模式：交易花费此前的输出并创建多个输出
+
+	GenesisAlloc   map[string]coin.Amount // 创世区块中预先分配给各账户的余额
+	LegacyAccounts []string               // 旧格式创世区块的链中，创世前各有 initialBalance 初始余额的账户
+}
+
+// 预置的网络参数，通过 --network 选择
+var networks = map[string]*ChainParams{
+	"main": {
+		Name:             "main",
+		ChainID:          "gamechain-main",
+		InitialBits:      0x2000ffff, // 约等于哈希前 2 位十六进制为 0
+		PowLimitBits:     0x200fffff, // 约等于哈希前 1 位十六进制为 0
+		RetargetInterval: 10,
+		TargetBlockTime:  60,
+		RetargetFactor:   4,
+		InitialSubsidy:   50 * coin.Coin,
+		HalvingInterval:  10000,
+		MaxSupply:        1_000_000 * coin.Coin,
+		CoinbaseMaturity: 10,
+		GenesisTimestamp: 1735689600, // 2025-01-01 00:00:00 UTC
+		// 已发布的旧格式链创建时账户文件中的账户，固定下来使各节点的初始状态一致
+		LegacyAccounts: []string{"Alice", "Bob", "Charls", "Dark"},
+	},
+	"test": {
+		Name:             "test",
+		ChainID:          "gamechain-test",
+		InitialBits:      0x200fffff,
+		PowLimitBits:     0x200fffff,
+		RetargetInterval: 5,
+		TargetBlockTime:  10,
+		RetargetFactor:   4,
+		InitialSubsidy:   50 * coin.Coin,
+		HalvingInterval:  100,
+		MaxSupply:        10_000 * coin.Coin,
+		CoinbaseMaturity: 3,
+		GenesisTimestamp: 1735689600,
+	},
+}
+
+// GetChainParams 按名称查找网络参数
+func GetChainParams(name string) (*ChainParams, error) {
+	params, exists := networks[name]
+	if !exists {
+		return nil, fmt.Errorf("未知网络: %s", name)
+	}
+	return params, nil
+}
+
+// acceptsTxVersion 判断网络是否接受 sender 发出的该版本的新格式交易。
+// 奖励交易和预分配交易始终只有一个接收方；UTXO 模式只接受 UTXO 交易，账户模式接受普通转账和批量转账
+func (p *ChainParams) acceptsTxVersion(sender string, version uint32) bool {
+	switch {
+	case sender == "System":
+		return version == currentTxVersion
+	case p.UTXO:
+		return version == utxoTxVersion
+	default:
+		return version == currentTxVersion || version == batchTxVersion
+	}
+}
+
+// Magic 返回网络消息帧头中的网络标识，取链 ID 的 SHA-256 前 4 字节，不同网络的节点互相拒绝对方的消息
+func (p *ChainParams) Magic() uint32 {
+	sum := sha256.Sum256([]byte(p.ChainID))
+	return binary.BigEndian.Uint32(sum[:4])
+}
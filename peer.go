@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gamechain/wire"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errSelfConnection = errors.New("连接到了自己")
+	errForeignNetwork = errors.New("对方属于其他网络")
+)
+
+// 节点会话参数
+const (
+	userAgent           = "/gamechain:1.0/"
+	handshakeTimeout    = 10 * time.Second // 完成 version/verack 握手的时限
+	pingInterval        = 30 * time.Second // 发送 ping 的间隔
+	peerIdleTimeout     = 90 * time.Second // 超过该时间没有收到任何消息则断开会话
+	writeTimeout        = 10 * time.Second // 单条消息的写入时限
+	reconnectInterval   = 10 * time.Second // 重新连接 --peers 中断开的节点的间隔
+	peerSendQueue       = 2048             // 每个会话待发送消息的队列长度，能容纳一条 getdata 的全部回复
+	maxHandshakePayload = 4 << 10          // 握手完成前对方未经验证，只接受 version、verack 这样的小消息
+)
+
+// Peer 与另一个节点之间完成握手的长连接。消息由独立的协程按顺序写出，读取循环按命令处理收到的消息
+type Peer struct {
+	Addr       string // 会话的标识：出站会话为拨号地址，入站会话为连接的对端地址
+	ListenAddr string // 对方的监听地址。入站会话中为对方声明的地址，未经验证，只作为可以尝试连接的地址
+	Inbound    bool   // 会话是否由对方发起
+	UserAgent  string
+	Connected  time.Time
+
+	nonce     uint64 // 对方 version 中的随机标识
+	node      *Node
+	conn      net.Conn
+	send      chan Message
+	known     *inventorySet // 对方已有或已向其宣告过的交易和区块，不再向其宣告
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	height    int           // 对方已知的最佳高度
+	latency   time.Duration // 最近一次 ping 的往返时间，尚未测得时为 0
+	pingNonce uint64        // 尚未收到 pong 的 ping，没有时为 0
+	pingSent  time.Time
+}
+
+func newPeer(node *Node, conn net.Conn, addr string, inbound bool) *Peer {
+	return &Peer{
+		Addr:    addr,
+		Inbound: inbound,
+		node:    node,
+		conn:    conn,
+		send:    make(chan Message, peerSendQueue),
+		known:   newInventorySet(maxKnownInv),
+		quit:    make(chan struct{}),
+	}
+}
+
+// randomNonce 返回非零的随机数
+func randomNonce() uint64 {
+	var buf [8]byte
+	for {
+		if _, err := rand.Read(buf[:]); err != nil {
+			panic(fmt.Sprintf("生成随机数失败: %v", err))
+		}
+		if nonce := binary.BigEndian.Uint64(buf[:]); nonce != 0 {
+			return nonce
+		}
+	}
+}
+
+// localNonce 返回本节点的随机标识，首次调用时生成
+func (node *Node) localNonce() uint64 {
+	node.peerMu.Lock()
+	defer node.peerMu.Unlock()
+	if node.nonce == 0 {
+		node.nonce = randomNonce()
+	}
+	return node.nonce
+}
+
+// versionMessage 生成本节点握手时发送的 version
+func (node *Node) versionMessage() VersionMessage {
+	nonce := node.localNonce()
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return VersionMessage{
+		ProtocolVersion: protocolVersion,
+		ChainID:         node.Blockchain.Params.ChainID,
+		GenesisHash:     node.Blockchain.Blocks[0].Hash,
+		BestHeight:      len(node.Blockchain.Blocks) - 1,
+		UserAgent:       userAgent,
+		ListenAddr:      node.Address,
+		Nonce:           nonce,
+	}
+}
+
+// checkVersion 检查对方的 version，不能建立会话时返回 reject 错误码和原因
+func (node *Node) checkVersion(version VersionMessage) (string, error) {
+	local := node.versionMessage()
+	switch {
+	case version.ProtocolVersion != protocolVersion:
+		return RejectVersion, fmt.Errorf("%w: %d", errProtocolVersion, version.ProtocolVersion)
+	case version.ChainID != local.ChainID:
+		return RejectGenesis, fmt.Errorf("%w: 链 ID 不同: %s", errForeignNetwork, version.ChainID)
+	case version.GenesisHash != local.GenesisHash:
+		return RejectGenesis, fmt.Errorf("%w: 创世区块不同: %s", errForeignNetwork, version.GenesisHash)
+	case version.Nonce == local.Nonce:
+		return RejectDuplicate, errSelfConnection
+	}
+	return "", nil
+}
+
+// isDisconnect 判断读取错误是否只是连接关闭或超时，而不是对方发送了无法解析的消息
+func isDisconnect(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// rejectReadError 对无法读取的消息回复 reject，对方已关闭连接时不回复
+func (node *Node) rejectReadError(conn net.Conn, err error) {
+	if isDisconnect(err) {
+		return
+	}
+	code := RejectMalformed
+	if errors.Is(err, errProtocolVersion) {
+		code = RejectVersion
+	}
+	node.reject(conn, "", code, err.Error())
+}
+
+// reject 回复 reject 消息
+func (node *Node) reject(conn net.Conn, command, code, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := node.writeMessage(conn, RejectMessage{Rejected: command, Code: code, Reason: reason}); err != nil {
+		fmt.Printf("回复 reject 消息失败: %v\n", err)
+	}
+}
+
+// handshake 发送本节点的 version，并等待对方的 version 和 verack。对方的 version 通过检查后回复 verack
+func (p *Peer) handshake() error {
+	node := p.node
+	p.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer p.conn.SetDeadline(time.Time{})
+
+	if err := node.writeMessage(p.conn, node.versionMessage()); err != nil {
+		return err
+	}
+	var gotVersion, gotVerack bool
+	for !gotVersion || !gotVerack {
+		msg, err := node.readMessage(p.conn, maxHandshakePayload)
+		if err != nil {
+			node.rejectReadError(p.conn, err)
+			return err
+		}
+		switch {
+		case msg.Command == CommandVersion && !gotVersion:
+			var version VersionMessage
+			if err := msg.Decode(&version); err != nil {
+				node.reject(p.conn, msg.Command, RejectMalformed, err.Error())
+				return err
+			}
+			if code, err := node.checkVersion(version); err != nil {
+				node.reject(p.conn, msg.Command, code, err.Error())
+				return err
+			}
+			p.ListenAddr = p.Addr
+			if p.Inbound {
+				p.ListenAddr = version.ListenAddr
+			}
+			p.nonce = version.Nonce
+			if node.AddrBook.IsBanned(p.banAddr()) {
+				err := fmt.Errorf("节点 %s 已被封禁", p.Addr)
+				node.reject(p.conn, msg.Command, RejectBanned, err.Error())
+				return err
+			}
+			p.UserAgent = version.UserAgent
+			p.height = version.BestHeight
+			gotVersion = true
+			if err := node.writeMessage(p.conn, VerackMessage{}); err != nil {
+				return err
+			}
+		case msg.Command == CommandVerack && !gotVerack:
+			gotVerack = true
+		default:
+			err := fmt.Errorf("握手期间收到 %s 消息", msg.Command)
+			node.reject(p.conn, msg.Command, RejectHandshake, err.Error())
+			return err
+		}
+	}
+	p.Connected = time.Now()
+	return nil
+}
+
+// run 启动写出协程并在当前协程读取消息，连接断开后从节点的会话列表中移除。会话开始时立即 ping 一次以测得延迟
+func (p *Peer) run() {
+	go p.writeLoop()
+	p.Send(p.newPing())
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(peerIdleTimeout))
+		msg, err := p.node.readMessage(p.conn, wire.MaxPayloadSize)
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				p.node.rejectReadError(p.conn, err)
+				fmt.Printf("与节点 %s 的连接已断开: %v\n", p.Addr, err)
+				if !isDisconnect(err) {
+					p.node.misbehaving(p, 20, err.Error())
+				}
+			}
+			break
+		}
+		p.node.handleMessage(p, msg)
+	}
+	p.close()
+	p.node.removePeer(p)
+}
+
+// writeLoop 按顺序写出待发送的消息，并定期发送 ping
+func (p *Peer) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		var msg Message
+		select {
+		case <-p.quit:
+			return
+		case msg = <-p.send:
+		case <-ticker.C:
+			msg = p.newPing()
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := p.node.writeMessage(p.conn, msg); err != nil {
+			fmt.Printf("向节点 %s 发送 %s 消息失败: %v\n", p.Addr, msg.Command(), err)
+			p.close()
+			return
+		}
+	}
+}
+
+// Send 将消息放入发送队列，会话已关闭时返回 false。队列已满说明对方长时间不读取，断开会话
+func (p *Peer) Send(msg Message) bool {
+	select {
+	case <-p.quit:
+		return false
+	default:
+	}
+	select {
+	case p.send <- msg:
+		return true
+	default:
+		fmt.Printf("节点 %s 的发送队列已满，断开连接\n", p.Addr)
+		p.close()
+		return false
+	}
+}
+
+// close 关闭会话，可以重复调用
+func (p *Peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// newPing 生成新的 ping 并记录发送时间
+func (p *Peer) newPing() PingMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pingNonce = randomNonce()
+	p.pingSent = time.Now()
+	return PingMessage{Nonce: p.pingNonce}
+}
+
+// handlePong 收到与最近一次 ping 对应的 pong 时更新往返延迟
+func (p *Peer) handlePong(nonce uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if nonce != 0 && nonce == p.pingNonce {
+		p.latency = time.Since(p.pingSent)
+		p.pingNonce = 0
+	}
+}
+
+// updateHeight 对方发来更高的区块时更新其最佳高度
+func (p *Peer) updateHeight(height int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if height > p.height {
+		p.height = height
+	}
+}
+
+// Stats 返回对方的最佳高度和最近一次测得的往返延迟
+func (p *Peer) Stats() (height int, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.height, p.latency
+}
+
+// sameNode 判断入站会话 in 与出站会话 out 是否连接同一个节点：in 声明的监听地址为 out 的拨号地址且双方标识相同
+func sameNode(in, out *Peer) bool {
+	return in.Inbound && !out.Inbound && in.ListenAddr == out.Addr && in.nonce == out.nonce
+}
+
+// duplicates 判断新会话是否与已有会话重复，返回 true 表示丢弃新会话，同时返回应由新会话替换的已有会话。
+// 调用方需持有 node.peerMu。同一地址已有会话时丢弃新会话。与同一节点同时有入站和出站会话时
+// （例如双方同时发起连接），标识较小的节点保留自己发起的出站会话并断开对方发起的入站会话，
+// 标识较大的节点不做处理，其出站会话随对方断开。入站会话声明的监听地址未经验证，
+// 因此入站会话从不替换出站会话，也不会阻止向该地址拨号
+func (node *Node) duplicates(p *Peer) (bool, []*Peer) {
+	if node.peers[p.Addr] != nil {
+		return true, nil
+	}
+	if node.nonce > p.nonce {
+		return false, nil
+	}
+	if p.Inbound {
+		outbound := node.peers[p.ListenAddr]
+		return outbound != nil && sameNode(p, outbound), nil
+	}
+	var replaced []*Peer
+	for _, other := range node.peers {
+		if sameNode(other, p) {
+			replaced = append(replaced, other)
+		}
+	}
+	return false, replaced
+}
+
+// addPeer 登记完成握手的会话，与已有会话重复时返回 false 表示新会话被丢弃
+func (node *Node) addPeer(p *Peer) bool {
+	node.peerMu.Lock()
+	if node.peers == nil {
+		node.peers = make(map[string]*Peer)
+	}
+	duplicate, replaced := node.duplicates(p)
+	if duplicate {
+		node.peerMu.Unlock()
+		node.reject(p.conn, CommandVersion, RejectDuplicate, "已存在与该节点的会话")
+		p.close()
+		return false
+	}
+	node.peers[p.Addr] = p
+	node.peerMu.Unlock()
+	for _, other := range replaced {
+		other.close()
+	}
+
+	height, _ := p.Stats()
+	direction := "出站"
+	if p.Inbound {
+		direction = "入站"
+	}
+	fmt.Printf("已与节点 %s 建立%s会话 (%s, 高度 %d)\n", p.Addr, direction, p.UserAgent, height)
+
+	// 出站会话向对方请求更多地址；入站节点声明的监听地址回拨成功后再转发给其他节点
+	if p.Inbound {
+		go node.verifyListenAddr(p)
+	} else {
+		node.AddrBook.Good(p.Addr)
+		p.Send(GetAddrMessage{})
+	}
+
+	// 对方的链更高时请求其区块头
+	node.mu.Lock()
+	behind := height > len(node.Blockchain.Blocks)-1
+	locator := node.Blockchain.blockLocator()
+	node.mu.Unlock()
+	if behind {
+		p.Send(GetHeadersMessage{Locator: locator})
+	}
+	return true
+}
+
+// removePeer 移除已断开的会话
+func (node *Node) removePeer(p *Peer) {
+	node.peerMu.Lock()
+	defer node.peerMu.Unlock()
+	if node.peers[p.Addr] == p {
+		delete(node.peers, p.Addr)
+	}
+}
+
+// peer 按地址查找已建立的会话，不存在时返回 nil
+func (node *Node) peer(addr string) *Peer {
+	node.peerMu.Lock()
+	defer node.peerMu.Unlock()
+	return node.peers[addr]
+}
+
+// Peers 返回全部已建立的会话，按地址排序
+func (node *Node) Peers() []*Peer {
+	node.peerMu.Lock()
+	defer node.peerMu.Unlock()
+	peers := make([]*Peer, 0, len(node.peers))
+	for _, p := range node.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Addr < peers[j].Addr })
+	return peers
+}
+
+// connectPeer 连接指定地址的节点并完成握手，成功后在后台运行会话
+func (node *Node) connectPeer(addr string) error {
+	conn, err := connectWithTimeout(addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	p := newPeer(node, conn, addr, false)
+	if err := p.handshake(); err != nil {
+		conn.Close()
+		if errors.Is(err, errSelfConnection) || errors.Is(err, errForeignNetwork) {
+			node.AddrBook.Remove(addr)
+		}
+		return fmt.Errorf("握手失败: %w", err)
+	}
+	if !node.addPeer(p) {
+		return fmt.Errorf("已存在与该节点的会话")
+	}
+	go p.run()
+	return nil
+}
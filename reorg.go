@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+)
+
+// ReorgEvent 描述一次链重组
+type ReorgEvent struct {
+	ForkIndex   int      // 新旧两条链共同祖先的区块编号
+	Depth       int      // 从主链上断开的区块数
+	OldTip      string   // 重组前的链尾哈希
+	NewTip      string   // 重组后的链尾哈希
+	AffectedTxs []string // 断开和接入区块中所有交易的 ID
+	ReturnedTxs []string // 退回交易池的交易 ID
+}
+
+// commonAncestor 返回两条链最后一个相同区块的位置，没有共同区块时返回 -1
+func commonAncestor(a, b []Block) int {
+	fork := -1
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].Hash != b[i].Hash {
+			break
+		}
+		fork = i
+	}
+	return fork
+}
+
+// Reorganize 将主链切换为 newBlocks（从创世区块开始的完整且已通过校验的链）。
+// 被断开区块中的非奖励交易若在新链上仍然有效则退回交易池
+func (bc *Blockchain) Reorganize(newBlocks []Block, publicKeys map[string]*ecdsa.PublicKey) ReorgEvent {
+	fork := commonAncestor(bc.Blocks, newBlocks)
+	disconnected := bc.Blocks[fork+1:]
+	connected := newBlocks[fork+1:]
+
+	event := ReorgEvent{
+		ForkIndex: fork,
+		Depth:     len(disconnected),
+		OldTip:    bc.Blocks[len(bc.Blocks)-1].Hash,
+		NewTip:    newBlocks[len(newBlocks)-1].Hash,
+	}
+
+	included := make(map[string]bool)
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			included[tx.ID()] = true
+			event.AffectedTxs = append(event.AffectedTxs, tx.ID())
+		}
+	}
+
+	// 被断开区块的交易排在原交易池之前，保持原有的先后顺序
+	candidates := []Transaction{}
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			event.AffectedTxs = append(event.AffectedTxs, tx.ID())
+			if tx.Sender != "System" {
+				candidates = append(candidates, tx)
+			}
+		}
+	}
+	returnable := len(candidates)
+	candidates = append(candidates, bc.TransactionPool...)
+
+	bc.Blocks = append([]Block{}, newBlocks...)
+
+	// 在新链的账本基础上依次重放候选交易，丢弃已上链、重复、nonce 不连续或余额不足的交易
+	l := bc.chainLedger()
+	seen := make(map[string]bool)
+	pool := []Transaction{}
+	for i, tx := range candidates {
+		hash := tx.ID()
+		if included[hash] || seen[hash] {
+			continue
+		}
+		if err := l.applyTransaction(tx, true); err != nil {
+			fmt.Printf("交易在新链上无效，已从交易池移除: %v\n", err)
+			continue
+		}
+		seen[hash] = true
+		pool = append(pool, tx)
+		if i < returnable {
+			event.ReturnedTxs = append(event.ReturnedTxs, hash)
+		}
+	}
+	bc.TransactionPool = pool
+
+	return event
+}
+
+// switchChain 将节点切换到 candidate 所代表的链，并按断开和接入的区块更新余额。调用方需持有 node.mu
+func (node *Node) switchChain(candidate *Blockchain) {
+	oldBlocks := node.Blockchain.Blocks
+	event := node.Blockchain.Reorganize(candidate.Blocks, node.PublicKeys)
+	SaveBlockchain(blockchainFile, node.Blockchain)
+	node.tipChanged()
+
+	node.disconnectBalances(oldBlocks[event.ForkIndex+1:])
+	node.connectBalances(node.Blockchain.Blocks[event.ForkIndex+1:])
+	node.retryFutureTxs()
+
+	if event.Depth > 0 {
+		node.emitReorg(event)
+	}
+}
+
+// OnReorg 注册链重组事件的监听函数
+func (node *Node) OnReorg(listener func(ReorgEvent)) {
+	node.reorgListeners = append(node.reorgListeners, listener)
+}
+
+func (node *Node) emitReorg(event ReorgEvent) {
+	fmt.Printf("链重组: 共同祖先 #%d, 深度 %d, 新链尾 %s, 受影响交易 %d 笔, 退回交易池 %d 笔\n",
+		event.ForkIndex, event.Depth, event.NewTip, len(event.AffectedTxs), len(event.ReturnedTxs))
+	for _, listener := range node.reorgListeners {
+		listener(event)
+	}
+}
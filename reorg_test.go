@@ -0,0 +1,41 @@
+package main
+
+import (
+	"gamechain/coin"
+	"testing"
+)
+
+func TestReorganizeReturnsOrphanedTransactionsToPool(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	genesis := bc.Blocks[0]
+
+	// 主链: 创世 -> B1(Alice->Bob 10) -> B2(Bob->Alice 150，在分叉链上余额不足)
+	payBob := NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, 0, 1, privateKeys["Alice"])
+	mineTestBlock(bc, []Transaction{payBob}, "Bob", publicKeys)
+	overspend := NewTransaction(testParams.ChainID, "Bob", "Alice", 150*coin.Coin, 0, 1, privateKeys["Bob"])
+	mineTestBlock(bc, []Transaction{overspend}, "Alice", publicKeys)
+
+	// 分叉链: 创世 -> C1 -> C2 -> C3，均为空块
+	fork := bc.withBlocks([]Block{genesis})
+	for i := 0; i < 3; i++ {
+		mineTestBlock(fork, []Transaction{}, "Alice", publicKeys)
+	}
+
+	event := bc.Reorganize(fork.Blocks, publicKeys)
+
+	if event.ForkIndex != 0 || event.Depth != 2 {
+		t.Errorf("期望共同祖先 #0、深度 2, 实际 #%d、深度 %d", event.ForkIndex, event.Depth)
+	}
+	if event.NewTip != fork.Blocks[3].Hash || bc.Blocks[len(bc.Blocks)-1].Hash != event.NewTip {
+		t.Error("主链未切换到分叉链")
+	}
+	if len(bc.TransactionPool) != 1 || bc.TransactionPool[0].ID() != payBob.ID() {
+		t.Fatalf("期望仅 Alice->Bob 交易退回交易池, 实际: %+v", bc.TransactionPool)
+	}
+	if len(event.ReturnedTxs) != 1 || event.ReturnedTxs[0] != payBob.ID() {
+		t.Errorf("退回交易记录不正确: %v", event.ReturnedTxs)
+	}
+	if len(event.AffectedTxs) != 7 {
+		t.Errorf("期望 7 笔受影响交易, 实际 %d 笔", len(event.AffectedTxs))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// 新格式交易的签名为定长的 r||s 十六进制字符串，r、s 各按曲线阶的字节长度左侧补零；
+// s 必须不大于曲线阶的一半（low-S），使同一签名只有唯一的合法编码。
+// 旧格式交易的签名为十进制的 "r:s"，只用于校验旧格式区块中的历史交易
+
+// scalarSize 返回曲线阶的字节长度
+func scalarSize(publicKey *ecdsa.PublicKey) int {
+	return (publicKey.Curve.Params().N.BitLen() + 7) / 8
+}
+
+// signHash 对哈希签名并返回规范化为 low-S 的定长签名
+func signHash(privateKey *ecdsa.PrivateKey, hash []byte) (string, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash)
+	if err != nil {
+		return "", fmt.Errorf("签名失败: %w", err)
+	}
+	n := privateKey.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	size := scalarSize(&privateKey.PublicKey)
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	s.FillBytes(sig[size:])
+	return hex.EncodeToString(sig), nil
+}
+
+// parseSignature 解析定长签名，长度不符、r 或 s 不在 [1, n-1] 内或 s 不是 low-S 时返回错误
+func parseSignature(signature string, publicKey *ecdsa.PublicKey) (*big.Int, *big.Int, error) {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("签名不是合法的十六进制: %w", err)
+	}
+	size := scalarSize(publicKey)
+	if len(sig) != 2*size {
+		return nil, nil, fmt.Errorf("签名长度应为 %d 字节, 实际 %d 字节", 2*size, len(sig))
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+
+	n := publicKey.Curve.Params().N
+	if r.Sign() == 0 || r.Cmp(n) >= 0 || s.Sign() == 0 || s.Cmp(n) >= 0 {
+		return nil, nil, fmt.Errorf("签名数值超出范围")
+	}
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		return nil, nil, fmt.Errorf("签名的 s 值不是 low-S 形式")
+	}
+	return r, s, nil
+}
+
+// parseLegacySignature 解析旧格式的十进制 "r:s" 签名
+func parseLegacySignature(signature string) (*big.Int, *big.Int, error) {
+	rText, sText, found := strings.Cut(signature, ":")
+	if !found {
+		return nil, nil, fmt.Errorf("旧格式签名缺少分隔符")
+	}
+	r, ok := new(big.Int).SetString(rText, 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("旧格式签名的 r 值无效: %q", rText)
+	}
+	s, ok := new(big.Int).SetString(sText, 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("旧格式签名的 s 值无效: %q", sText)
+	}
+	return r, s, nil
+}
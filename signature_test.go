@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gamechain/account"
+	"gamechain/coin"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSignatureRoundTripWithStoredAccounts(t *testing.T) {
+	accountsFile := filepath.Join(t.TempDir(), "accounts.json")
+	var accounts []account.Account
+	privateKeys := make(map[string]*ecdsa.PrivateKey)
+	publicKeys := make(map[string]*ecdsa.PublicKey)
+	for _, name := range []string{"Alice", "Bob"} {
+		if err := account.CreateNewAccount(name, &accounts, privateKeys, publicKeys, accountsFile, "test-key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, loadedKeys, err := account.LoadAccounts(accountsFile, "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := privateKeys["Alice"].Curve.Params().N
+	halfOrder := new(big.Int).Rsh(n, 1)
+	for i := 0; i < 32; i++ {
+		tx := NewTransaction(testParams.ChainID, "Alice", "Bob", coin.Amount(i+1)*coin.Coin, 0, uint64(i+1), privateKeys["Alice"])
+		if len(tx.Signature) != 128 {
+			t.Fatalf("签名长度应为 128 个十六进制字符, 实际 %d", len(tx.Signature))
+		}
+		if !VerifyTransaction(&tx, testParams.ChainID, loadedKeys["Alice"]) {
+			t.Fatalf("交易 %d 应能用加载的公钥验证", i)
+		}
+		if VerifyTransaction(&tx, testParams.ChainID, loadedKeys["Bob"]) {
+			t.Fatalf("交易 %d 不应能用其他账户的公钥验证", i)
+		}
+		if _, s, _ := parseSignature(tx.Signature, loadedKeys["Alice"]); s.Cmp(halfOrder) > 0 {
+			t.Fatalf("交易 %d 的签名不是 low-S 形式", i)
+		}
+	}
+}
+
+func TestVerifyTransactionRejectsMalformedSignatures(t *testing.T) {
+	privateKey, publicKey := account.GenerateKeyPair()
+	tx := NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, 0, 1, privateKey)
+	sig, _ := hex.DecodeString(tx.Signature)
+
+	// 将 s 换成 n-s 得到同样能通过数学验证的高 S 签名
+	n := publicKey.Curve.Params().N
+	highS := new(big.Int).Sub(n, new(big.Int).SetBytes(sig[32:]))
+	malleated := append([]byte{}, sig[:32]...)
+	malleated = append(malleated, highS.FillBytes(make([]byte, 32))...)
+
+	cases := map[string]string{
+		"高 S 签名": hex.EncodeToString(malleated),
+		"长度不足":   tx.Signature[:126],
+		"非十六进制":  strings.Repeat("zz", 64),
+		"r 为零":   strings.Repeat("0", 64) + tx.Signature[64:],
+		"旧格式签名":  "1:2",
+		"空签名":    "",
+	}
+	for name, signature := range cases {
+		t.Run(name, func(t *testing.T) {
+			bad := tx
+			bad.Signature = signature
+			if VerifyTransaction(&bad, testParams.ChainID, publicKey) {
+				t.Error("格式错误的签名不应通过验证")
+			}
+		})
+	}
+}
+
+func TestVerifyLegacyTransaction(t *testing.T) {
+	privateKey, publicKey := account.GenerateKeyPair()
+	tx := Transaction{Sender: "Alice", Receiver: "Bob", Amount: 10 * coin.Coin}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s%s%f", tx.Sender, tx.Receiver, tx.Amount.Float64())))
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Signature = fmt.Sprintf("%s:%s", r.String(), s.String())
+
+	if !VerifyTransaction(&tx, testParams.ChainID, publicKey) {
+		t.Error("旧格式的 r:s 签名应通过验证")
+	}
+	tx.Amount = 11 * coin.Coin
+	if VerifyTransaction(&tx, testParams.ChainID, publicKey) {
+		t.Error("被篡改的旧格式交易不应通过验证")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"gamechain/account"
+	"gamechain/coin"
+)
+
+// blockTransfers 返回区块中各交易对账户余额的影响。多输出交易的每个输出各为一笔转账，手续费计入第一笔
+func blockTransfers(block *Block) []account.Transfer {
+	transfers := make([]account.Transfer, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		fee := tx.Fee
+		for _, out := range tx.outputs() {
+			transfers = append(transfers, account.Transfer{
+				From:   tx.Sender,
+				To:     out.Receiver,
+				Amount: out.Amount,
+				Fee:    fee,
+			})
+			fee = 0
+		}
+	}
+	return transfers
+}
+
+// genesisBalances 返回执行创世区块之前每个账户的余额
+func (bc *Blockchain) genesisBalances() map[string]coin.Amount {
+	return newLedger(&bc.Blocks[0], bc.Params).balances
+}
+
+// chainBalances 从创世状态开始依次执行主链上的区块，得到余额状态
+func (bc *Blockchain) chainBalances() (*account.BalanceManager, error) {
+	balances := account.NewBalanceManager()
+	balances.Reset(bc.genesisBalances())
+	for i := range bc.Blocks {
+		if err := balances.ApplyBlock(blockTransfers(&bc.Blocks[i])); err != nil {
+			return nil, fmt.Errorf("区块 #%d: %w", bc.Blocks[i].Header.Index, err)
+		}
+	}
+	return balances, nil
+}
+
+// connectBalances 在区块接入主链后执行其中的转账并保存余额缓存，UTXO 模式下同时更新 UTXO 索引，调用方需持有 node.mu
+func (node *Node) connectBalances(blocks []Block) {
+	for i := range blocks {
+		err := node.BalanceManager.ApplyBlock(blockTransfers(&blocks[i]))
+		if err == nil && node.utxos != nil {
+			err = node.utxos.ConnectBlock(&blocks[i])
+		}
+		if err != nil {
+			fmt.Printf("余额状态与区块 #%d 不一致，从区块链重建: %v\n", blocks[i].Header.Index, err)
+			if _, err := node.rebuildBalances(); err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+	}
+	node.saveBalances()
+}
+
+// disconnectBalances 在区块从主链断开后按从链尾到分叉点的顺序撤销其中的转账，
+// UTXO 模式下同时恢复被花费的输出，调用方需持有 node.mu
+func (node *Node) disconnectBalances(blocks []Block) {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		err := node.BalanceManager.RevertBlock(blockTransfers(&blocks[i]))
+		if err == nil && node.utxos != nil {
+			err = node.utxos.DisconnectBlock(&blocks[i])
+		}
+		if err != nil {
+			fmt.Printf("余额状态与区块 #%d 不一致，从区块链重建: %v\n", blocks[i].Header.Index, err)
+			if _, err := node.rebuildBalances(); err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+	}
+	node.saveBalances()
+}
+
+// rebuildBalances 丢弃余额缓存（以及 UTXO 索引），从创世状态开始按主链区块重新计算全部余额。
+// 返回原有余额缓存是否与区块链一致，调用方需持有 node.mu
+func (node *Node) rebuildBalances() (bool, error) {
+	rebuilt, err := node.Blockchain.chainBalances()
+	if err != nil {
+		return false, fmt.Errorf("从区块链重建余额失败: %w", err)
+	}
+	node.utxos = nil
+	if node.Blockchain.Params.UTXO {
+		if node.utxos, err = node.Blockchain.chainUTXOs(); err != nil {
+			return false, fmt.Errorf("从区块链重建 UTXO 索引失败: %w", err)
+		}
+	}
+
+	expected := make(map[string]coin.Amount)
+	for _, name := range rebuilt.GetAllAccounts() {
+		expected[name], _ = rebuilt.GetBalance(name)
+	}
+	consistent := len(expected) == len(node.BalanceManager.GetAllAccounts())
+	for name, balance := range expected {
+		if cached, exists := node.BalanceManager.GetBalance(name); !exists || cached != balance {
+			consistent = false
+		}
+	}
+
+	node.BalanceManager.Reset(expected)
+	node.saveBalances()
+	return consistent, nil
+}
+
+func (node *Node) saveBalances() {
+	if err := node.BalanceManager.SaveBalances(balancesFile); err != nil {
+		fmt.Printf("保存余额失败: %v\n", err)
+	}
+}
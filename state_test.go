@@ -0,0 +1,101 @@
+package main
+
+import (
+	"gamechain/account"
+	"gamechain/coin"
+	"os"
+	"testing"
+)
+
+func TestBalancesFollowReorganize(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	genesis := bc.Blocks[0]
+	mineTestBlock(bc, []Transaction{NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, coin.Coin, 1, privateKeys["Alice"])}, "Bob", publicKeys)
+
+	node := &Node{Blockchain: bc, PublicKeys: publicKeys, BalanceManager: account.NewBalanceManager(), FutureTxs: NewFutureTxPool()}
+	chdirTemp(t)
+	if _, err := node.rebuildBalances(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分叉链从创世区块开始，比主链多一个区块
+	fork := bc.withBlocks([]Block{genesis})
+	mineTestBlock(fork, nil, "Alice", publicKeys)
+	mineTestBlock(fork, nil, "Alice", publicKeys)
+	node.switchChain(fork)
+
+	expected, err := node.Blockchain.chainBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Alice", "Bob"} {
+		got, _ := node.BalanceManager.GetBalance(name)
+		want, _ := expected.GetBalance(name)
+		if got != want {
+			t.Errorf("重组后 %s 的余额为 %s, 期望 %s", name, got, want)
+		}
+	}
+	if got, _ := node.BalanceManager.GetBalance("Alice"); got != testParams.GenesisAlloc["Alice"]+2*testParams.InitialSubsidy {
+		t.Errorf("Alice 的余额应为初始余额加两个区块的奖励, 实际 %s", got)
+	}
+}
+
+// chdirTemp 切换到临时目录，避免节点保存的区块链和余额文件覆盖仓库中的数据
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestAddTransactionToPoolChecksBalance(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	poolFile := t.TempDir() + "/pool.json"
+
+	first := NewTransaction(testParams.ChainID, "Alice", "Bob", 60*coin.Coin, 0, 1, privateKeys["Alice"])
+	if !bc.AddTransactionToPool(first, publicKeys, poolFile) {
+		t.Fatal("余额足够的交易应被接受")
+	}
+	// 交易池中的第一笔交易已占用 60，剩余余额不足以支付第二笔
+	second := NewTransaction(testParams.ChainID, "Alice", "Bob", 40*coin.Coin, coin.Unit, 2, privateKeys["Alice"])
+	if bc.AddTransactionToPool(second, publicKeys, poolFile) {
+		t.Error("余额不足以支付金额和手续费的交易应被拒绝")
+	}
+}
+
+func TestStateRootIndependentOfLocalAccounts(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	mineTestBlock(bc, []Transaction{NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, 0, 1, privateKeys["Alice"])}, "Bob", publicKeys)
+	root := bc.chainLedger().stateRoot()
+	if root != bc.Blocks[1].Header.StateRoot {
+		t.Fatalf("链尾状态根 %s 与重放结果 %s 不一致", bc.Blocks[1].Header.StateRoot, root)
+	}
+
+	// 本地新建的账户不影响链的校验
+	_, publicKeys["Carol"] = account.GenerateKeyPair()
+	if err := bc.ValidateChain(publicKeys); err != nil {
+		t.Errorf("新增账户后链应仍通过校验: %v", err)
+	}
+
+	l := bc.chainLedger()
+	l.balances["Carol"]++
+	if l.stateRoot() == root {
+		t.Error("账户余额变化后状态根应改变")
+	}
+
+	// 旧格式创世区块的初始余额只发给网络参数中固定的账户，尚未发生交易的账户不参与状态根
+	params := *testParams
+	params.LegacyAccounts = []string{"Alice", "Bob"}
+	legacy := newLedger(&Block{Header: BlockHeader{PreviousHash: "0"}}, &params)
+	if len(legacy.balances) != 2 || legacy.balances["Alice"] != initialBalance || legacy.balances["Carol"] != 0 {
+		t.Errorf("旧格式链的初始余额不正确: %v", legacy.balances)
+	}
+	if got := legacy.stateRoot(); got != zeroHash {
+		t.Errorf("初始状态的状态根应为全零哈希, 实际 %s", got)
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 区块头优先同步参数
+const (
+	maxHeadersPerMessage = 2000             // 每条 headers 最多包含的区块头数，收到满额时继续请求后续区块头
+	maxLocatorHashes     = 64               // 区块定位器最多包含的哈希数
+	maxBlocksPerRequest  = 16               // 每条 getblocks 最多请求的区块数
+	maxBlocksInFlight    = 64               // 每个节点同时请求中的区块数上限
+	blockRequestTimeout  = 30 * time.Second // 区块请求超过该时间未收到回复时改向其他节点请求
+)
+
+// blockRequest 已向节点请求、尚未收到的区块
+type blockRequest struct {
+	peer  *Peer
+	index int // 区块在目标链中的位置
+	sent  time.Time
+}
+
+// headerSync 区块头优先同步的状态，由 mu 保护，零值表示没有进行中的同步。
+// 先向节点请求区块头并校验整条区块头链的工作量证明，累计工作量大于本地主链时以其为目标链，
+// 再从主链包含目标链的多个节点并行下载缺少的区块，按顺序接入主链
+type headerSync struct {
+	mu       sync.Mutex
+	target   *Blockchain             // 已校验区块头的目标链：共同祖先及之前为本地主链的区块，之后的区块只有区块头
+	fork     int                     // 目标链与本地主链最后一个相同区块的位置
+	sources  map[*Peer]bool          // 主链包含目标链的节点，从这些节点下载区块
+	tips     map[*Peer]int           // 宣告过目标链区块头的节点及其链尾在目标链中的位置
+	bodies   map[string]Block        // 已下载、尚未接入主链的区块
+	inFlight map[string]blockRequest // 请求中的区块
+	batches  map[*Peer][][]string    // 每个节点尚未回复的 getblocks，按发送顺序排列
+	invalid  map[string]bool         // 区块校验失败的区块哈希，包含这些区块的区块头链不再作为目标链
+	partial  map[*Peer]*Blockchain   // 满额但累计工作量尚未超过本地主链的区块头链，等待对方的后续区块头
+}
+
+// reset 结束同步，保留尚未回复的 getblocks 记录，以便对应的回复到达时正确匹配
+func (s *headerSync) reset() {
+	s.target = nil
+	s.sources = nil
+	s.tips = nil
+	s.bodies = nil
+	s.inFlight = nil
+}
+
+// setTarget 以新的区块头链为目标链。新目标链是原目标链的延续时保留下载来源和已下载的区块
+func (s *headerSync) setTarget(target *Blockchain) {
+	extends := s.target != nil && target.FindBlock(s.target.Blocks[len(s.target.Blocks)-1].Hash) >= 0
+	if !extends {
+		s.reset()
+		s.sources = make(map[*Peer]bool)
+		s.tips = make(map[*Peer]int)
+		s.bodies = make(map[string]Block)
+		s.inFlight = make(map[string]blockRequest)
+		if s.batches == nil {
+			s.batches = make(map[*Peer][][]string)
+		}
+		if s.invalid == nil {
+			s.invalid = make(map[string]bool)
+		}
+	}
+	s.target = target
+	s.fork = 0
+}
+
+// updateFork 按本地主链的当前状态更新共同祖先的位置，主链可能已通过广播接入了目标链的区块或发生了重组
+func (s *headerSync) updateFork(main *Blockchain) {
+	s.fork = min(s.fork, len(main.Blocks)-1, len(s.target.Blocks)-1)
+	for s.fork > 0 && main.Blocks[s.fork].Hash != s.target.Blocks[s.fork].Hash {
+		s.fork--
+	}
+	for s.fork+1 < len(main.Blocks) && s.fork+1 < len(s.target.Blocks) && main.Blocks[s.fork+1].Hash == s.target.Blocks[s.fork+1].Hash {
+		s.fork++
+	}
+}
+
+// blockLocator 返回区块定位器：链尾开始的 10 个区块哈希，之后间隔逐次加倍，最后是创世区块
+func (bc *Blockchain) blockLocator() []string {
+	var locator []string
+	step := 1
+	for i := len(bc.Blocks) - 1; i > 0; i -= step {
+		locator = append(locator, bc.Blocks[i].Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, bc.Blocks[0].Hash)
+}
+
+// locateHeaders 响应 getheaders，从 locator 中第一个位于主链上的区块之后开始返回区块头，
+// 都不在主链上时从创世区块之后开始
+func (bc *Blockchain) locateHeaders(locator []string, stop string) []BlockHeader {
+	start := 1
+	for _, hash := range locator {
+		if index := bc.FindBlock(hash); index >= 0 {
+			start = index + 1
+			break
+		}
+	}
+	var headers []BlockHeader
+	for i := start; i < len(bc.Blocks) && len(headers) < maxHeadersPerMessage; i++ {
+		headers = append(headers, bc.Blocks[i].Header)
+		if bc.Blocks[i].Hash == stop {
+			break
+		}
+	}
+	return headers
+}
+
+// connectHeaders 把连续的区块头接在链中其前一区块之后，逐个校验链接、难度目标和工作量证明，
+// 返回只含区块头的候选链。第一个区块头的前一区块必须在链中
+func (bc *Blockchain) connectHeaders(headers []BlockHeader) (*Blockchain, error) {
+	fork := bc.FindBlock(headers[0].PreviousHash)
+	candidate := bc.withBlocks(append([]Block(nil), bc.Blocks[:fork+1]...))
+	for _, header := range headers {
+		block := Block{Header: header}
+		block.Hash = block.CalculateHash()
+		prev := &candidate.Blocks[len(candidate.Blocks)-1]
+		height := len(candidate.Blocks)
+		if err := validateHeader(&block, prev, candidate.NextBits(), candidate.medianTimePast(height)); err != nil {
+			return nil, &ChainValidationError{Index: header.Index, Hash: block.Hash, Err: err}
+		}
+		candidate.Blocks = append(candidate.Blocks, block)
+	}
+	return candidate, nil
+}
+
+// SyncBlockchain 向所有已连接的节点发送区块定位器请求区块头，收到后由 handleHeaders 校验并下载缺少的区块
+func (node *Node) SyncBlockchain() {
+	peers := node.Peers()
+	if len(peers) == 0 {
+		fmt.Println("没有已连接的节点，无法同步")
+		return
+	}
+	node.mu.Lock()
+	request := GetHeadersMessage{Locator: node.Blockchain.blockLocator()}
+	node.mu.Unlock()
+	for _, p := range peers {
+		p.Send(request)
+	}
+	fmt.Printf("开始同步区块链，已向 %d 个节点请求区块头\n", len(peers))
+}
+
+// handleGetHeaders 返回本地主链上对方缺少的区块头
+func (node *Node) handleGetHeaders(p *Peer, msg GetHeadersMessage) {
+	if len(msg.Locator) > maxLocatorHashes {
+		node.misbehaving(p, 20, fmt.Sprintf("区块定位器包含 %d 个哈希", len(msg.Locator)))
+		return
+	}
+	node.mu.Lock()
+	headers := node.Blockchain.locateHeaders(msg.Locator, msg.Stop)
+	node.mu.Unlock()
+	reply, err := encodeHeaders(headers)
+	if err != nil {
+		fmt.Printf("编码区块头失败: %v\n", err)
+		return
+	}
+	p.Send(reply)
+}
+
+// encodeHeaders 将区块头按二进制格式编码为 headers 消息
+func encodeHeaders(headers []BlockHeader) (HeadersMessage, error) {
+	msg := HeadersMessage{Headers: make([][]byte, len(headers))}
+	for i := range headers {
+		data, err := headers[i].MarshalBinary()
+		if err != nil {
+			return HeadersMessage{}, fmt.Errorf("区块头 #%d: %w", headers[i].Index, err)
+		}
+		msg.Headers[i] = data
+	}
+	return msg, nil
+}
+
+// decodeHeaders 解析 headers 消息中的二进制区块头
+func decodeHeaders(msg HeadersMessage) ([]BlockHeader, error) {
+	headers := make([]BlockHeader, len(msg.Headers))
+	for i, data := range msg.Headers {
+		if err := headers[i].UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("第 %d 个区块头: %w", i, err)
+		}
+	}
+	return headers, nil
+}
+
+// handleHeaders 校验对方发来的区块头链（包含已知无效区块的链视为无效），累计工作量大于本地主链和当前目标链时以其为目标链，
+// 与目标链一致时把对方加入下载来源，然后请求缺少的区块
+func (node *Node) handleHeaders(p *Peer, msg HeadersMessage) {
+	if len(msg.Headers) > maxHeadersPerMessage {
+		node.misbehaving(p, 20, fmt.Sprintf("headers 消息包含 %d 个区块头", len(msg.Headers)))
+		return
+	}
+	if len(msg.Headers) == 0 {
+		return
+	}
+	headers, err := decodeHeaders(msg)
+	if err != nil {
+		fmt.Printf("节点 %s 的区块头无法解析: %v\n", p.Addr, err)
+		p.Send(RejectMessage{Rejected: CommandHeaders, Code: RejectMalformed, Reason: err.Error()})
+		node.misbehaving(p, 20, err.Error())
+		return
+	}
+	p.updateHeight(headers[len(headers)-1].Index)
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	s := &node.download
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 接在目标链或对方上一批区块头上的区块头是其后续，否则应接在本地主链上
+	base := node.Blockchain
+	if s.target != nil && s.target.FindBlock(headers[0].PreviousHash) >= 0 {
+		base = s.target
+	} else if partial := s.partial[p]; partial != nil && partial.FindBlock(headers[0].PreviousHash) >= 0 {
+		base = partial
+	} else if base.FindBlock(headers[0].PreviousHash) < 0 {
+		fmt.Printf("节点 %s 的区块头 #%d 的前一区块未知，已忽略\n", p.Addr, headers[0].Index)
+		return
+	}
+	candidate, err := base.connectHeaders(headers)
+	if err == nil {
+		err = s.checkInvalid(candidate.Blocks[len(candidate.Blocks)-len(headers):])
+	}
+	if err != nil {
+		fmt.Printf("节点 %s 的区块头校验失败: %v\n", p.Addr, err)
+		if isPeerFault(err) {
+			node.misbehaving(p, 50, err.Error())
+		}
+		return
+	}
+	delete(s.partial, p)
+
+	// 满额时无论累计工作量是否已超过本地链都继续请求后续区块头，超过 maxHeadersPerMessage 的深分叉需要多批区块头
+	full := len(headers) == maxHeadersPerMessage
+	if full {
+		p.Send(GetHeadersMessage{Locator: candidate.blockLocator()})
+	}
+	tip := candidate.Blocks[len(candidate.Blocks)-1]
+	switch {
+	case s.target != nil && s.target.FindBlock(tip.Hash) >= 0:
+		// 对方的主链与目标链一致
+	case candidate.HasMoreWorkThan(node.Blockchain) && (s.target == nil || candidate.HasMoreWorkThan(s.target)):
+		s.setTarget(candidate)
+		s.updateFork(node.Blockchain)
+		fmt.Printf("节点 %s 的区块头已通过校验，目标高度 %d，需要下载 %d 个区块\n",
+			p.Addr, tip.Header.Index, len(candidate.Blocks)-1-s.fork)
+	case full:
+		if s.partial == nil {
+			s.partial = make(map[*Peer]*Blockchain)
+		}
+		s.partial[p] = candidate
+		return
+	default:
+		if s.target == nil {
+			fmt.Printf("节点 %s 的链累计工作量不大于本地链，无需更新\n", p.Addr)
+		}
+		return
+	}
+	s.sources[p] = true
+	s.tips[p] = len(candidate.Blocks) - 1
+	node.fetchBlocks()
+}
+
+// checkInvalid 检查区块头链中是否有已知校验失败的区块
+func (s *headerSync) checkInvalid(blocks []Block) error {
+	for _, block := range blocks {
+		if s.invalid[block.Hash] || s.invalid[block.Header.PreviousHash] {
+			return fmt.Errorf("区块 #%d (%s) 或其前一区块已校验失败", block.Header.Index, block.Hash)
+		}
+	}
+	return nil
+}
+
+// retrySync 重新分配超时或来源已断开的区块请求，由节点维护循环定期调用
+func (node *Node) retrySync() {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.download.mu.Lock()
+	defer node.download.mu.Unlock()
+	node.fetchBlocks()
+}
+
+// fetchBlocks 把目标链上尚未下载的区块分配给请求中区块最少的下载来源，
+// 每个节点同时最多请求 maxBlocksInFlight 个。调用方需持有 node.mu 和 node.download.mu
+func (node *Node) fetchBlocks() {
+	s := &node.download
+	connected := func(p *Peer) bool { return node.peer(p.Addr) == p }
+	for p := range s.partial {
+		if !connected(p) {
+			delete(s.partial, p)
+		}
+	}
+	if s.target == nil {
+		return
+	}
+	now := time.Now()
+	for p := range s.batches {
+		if !connected(p) {
+			delete(s.batches, p)
+		}
+	}
+
+	// 超时未回复的节点不再作为下载来源
+	load := make(map[*Peer]int)
+	for hash, request := range s.inFlight {
+		if now.Sub(request.sent) >= blockRequestTimeout || !connected(request.peer) {
+			delete(s.inFlight, hash)
+			delete(s.sources, request.peer)
+			continue
+		}
+		load[request.peer]++
+	}
+	var sources []*Peer
+	for p := range s.sources {
+		if connected(p) {
+			sources = append(sources, p)
+		} else {
+			delete(s.sources, p)
+		}
+	}
+	if len(sources) == 0 {
+		fmt.Println("没有可以下载区块的节点，同步暂停，可以稍后重新执行 sync")
+		s.reset()
+		return
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Addr < sources[j].Addr })
+
+	// 本地主链已通过其他途径超过目标链时，目标链不再需要下载
+	if !s.target.HasMoreWorkThan(node.Blockchain) {
+		s.reset()
+		return
+	}
+	s.updateFork(node.Blockchain)
+	pending := make(map[*Peer][]string)
+	for i := s.fork + 1; i < len(s.target.Blocks); i++ {
+		hash := s.target.Blocks[i].Hash
+		if _, downloaded := s.bodies[hash]; downloaded {
+			continue
+		}
+		if _, requested := s.inFlight[hash]; requested {
+			continue
+		}
+		var best *Peer
+		for _, p := range sources {
+			if load[p] < maxBlocksInFlight && (best == nil || load[p] < load[best]) {
+				best = p
+			}
+		}
+		if best == nil {
+			break
+		}
+		load[best]++
+		pending[best] = append(pending[best], hash)
+		s.inFlight[hash] = blockRequest{peer: best, index: i, sent: now}
+	}
+	for p, hashes := range pending {
+		for len(hashes) > 0 {
+			n := min(len(hashes), maxBlocksPerRequest)
+			s.batches[p] = append(s.batches[p], hashes[:n])
+			p.Send(GetBlocksMessage{Hashes: hashes[:n]})
+			hashes = hashes[n:]
+		}
+	}
+}
+
+// handleGetBlocks 返回请求的区块中本地主链上有的部分
+func (node *Node) handleGetBlocks(p *Peer, msg GetBlocksMessage) {
+	if len(msg.Hashes) > maxBlocksPerRequest {
+		node.misbehaving(p, 20, fmt.Sprintf("getblocks 消息请求 %d 个区块", len(msg.Hashes)))
+		return
+	}
+	node.mu.Lock()
+	var blocks []Block
+	for _, hash := range msg.Hashes {
+		if index := node.Blockchain.FindBlock(hash); index >= 0 {
+			blocks = append(blocks, node.Blockchain.Blocks[index])
+		}
+	}
+	node.mu.Unlock()
+	p.Send(BlocksMessage{Blocks: blocks})
+}
+
+// handleBlocks 核对下载的区块与目标链的区块头一致后暂存，对方没有返回的区块改向其他节点请求，
+// 然后把已下载的连续区块接入主链并继续分配请求
+func (node *Node) handleBlocks(p *Peer, msg BlocksMessage) {
+	if len(msg.Blocks) > maxBlocksPerRequest {
+		node.misbehaving(p, 20, fmt.Sprintf("blocks 消息包含 %d 个区块", len(msg.Blocks)))
+		return
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	s := &node.download
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 回复按请求的顺序到达，对应该节点最早一条尚未回复的 getblocks
+	var batch []string
+	if len(s.batches[p]) > 0 {
+		batch = s.batches[p][0]
+		s.batches[p] = s.batches[p][1:]
+	}
+	if s.target == nil {
+		return
+	}
+
+	for _, block := range msg.Blocks {
+		request, requested := s.inFlight[block.Hash]
+		if !requested || request.peer != p {
+			continue
+		}
+		delete(s.inFlight, block.Hash)
+		expected := s.target.Blocks[request.index]
+		if block.Header != expected.Header || block.CalculateHash() != expected.Hash ||
+			CalculateMerkleRoot(block.Transactions) != block.Header.MerkleRoot {
+			fmt.Printf("节点 %s 返回的区块 #%d 与区块头不符\n", p.Addr, expected.Header.Index)
+			delete(s.sources, p)
+			node.misbehaving(p, 50, "区块与区块头不符")
+			continue
+		}
+		s.bodies[block.Hash] = block
+	}
+	for _, hash := range batch {
+		if request, requested := s.inFlight[hash]; requested && request.peer == p {
+			delete(s.inFlight, hash)
+			delete(s.sources, p)
+		}
+	}
+
+	node.connectDownloaded()
+	node.fetchBlocks()
+}
+
+// connectDownloaded 把共同祖先之后已下载的连续区块接入主链。分叉时等到下载的分支累计工作量超过主链后再切换。
+// 区块校验失败时放弃目标链。调用方需持有 node.mu 和 node.download.mu
+func (node *Node) connectDownloaded() {
+	s := &node.download
+	s.updateFork(node.Blockchain)
+	var blocks []Block
+	for i := s.fork + 1; i < len(s.target.Blocks); i++ {
+		block, downloaded := s.bodies[s.target.Blocks[i].Hash]
+		if !downloaded {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	candidate := node.Blockchain.withBlocks(append(append([]Block(nil), node.Blockchain.Blocks[:s.fork+1]...), blocks...))
+	if !candidate.HasMoreWorkThan(node.Blockchain) {
+		return
+	}
+	if err := candidate.validateFrom(s.fork+1, node.PublicKeys); err != nil {
+		// 区块已与区块头核对一致，校验失败说明区块头链本身无效：放弃目标链并记住无效的区块，
+		// 惩罚宣告过该区块头的节点。取决于本地时钟或账户的错误只放弃目标链
+		fmt.Printf("下载的区块校验失败，放弃同步: %v\n", err)
+		var invalid *ChainValidationError
+		if errors.As(err, &invalid) && isPeerFault(err) {
+			s.invalid[invalid.Hash] = true
+			for p, tip := range s.tips {
+				if tip >= invalid.Index {
+					node.misbehaving(p, 50, err.Error())
+				}
+			}
+		}
+		s.reset()
+		return
+	}
+
+	node.switchChain(candidate)
+	node.connectOrphans()
+	for _, block := range blocks {
+		delete(s.bodies, block.Hash)
+	}
+	s.updateFork(node.Blockchain)
+	height := len(node.Blockchain.Blocks) - 1
+	targetHeight := len(s.target.Blocks) - 1
+	fmt.Printf("同步进度: 高度 %d/%d (%.1f%%)\n", height, targetHeight, 100*float64(height)/float64(targetHeight))
+	if s.fork == targetHeight {
+		fmt.Printf("区块链同步完成，当前高度 %d\n", height)
+		s.reset()
+		node.announce(InvVector{InvTypeBlock, node.Blockchain.Blocks[height].Hash})
+	}
+}
+
+// handleSyncCommand 不带参数时开始同步，sync status 查看同步进度
+func (node *Node) handleSyncCommand(args []string) {
+	if len(args) == 0 {
+		node.SyncBlockchain()
+		return
+	}
+	if args[0] != "status" {
+		fmt.Println("用法: sync [status]")
+		return
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	s := &node.download
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	height := len(node.Blockchain.Blocks) - 1
+	if s.target == nil {
+		fmt.Printf("当前没有进行中的同步，本地高度 %d\n", height)
+		return
+	}
+	fmt.Printf("正在从 %d 个节点同步: 本地高度 %d，目标高度 %d，已下载待接入 %d 个区块，请求中 %d 个区块\n",
+		len(s.sources), height, len(s.target.Blocks)-1, len(s.bodies), len(s.inFlight))
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"gamechain/coin"
+	"net"
+	"testing"
+)
+
+func TestBlockLocatorFindsForkPoint(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	for i := 0; i < 30; i++ {
+		mineTestBlock(bc, nil, "Alice", publicKeys)
+	}
+	locator := bc.blockLocator()
+	if locator[0] != bc.Blocks[30].Hash || locator[len(locator)-1] != bc.Blocks[0].Hash || len(locator) >= 20 {
+		t.Fatalf("定位器应从链尾开始逐渐稀疏并以创世区块结束: %d 个哈希", len(locator))
+	}
+
+	// 分叉链在 #20 之后与主链不同，主链从共同祖先之后返回区块头
+	fork := bc.withBlocks(append([]Block(nil), bc.Blocks[:21]...))
+	for i := 0; i < 3; i++ {
+		mineTestBlock(fork, nil, "Bob", publicKeys)
+	}
+	headers := bc.locateHeaders(fork.blockLocator(), "")
+	if len(headers) != 10 || headers[0] != bc.Blocks[21].Header {
+		t.Fatalf("应返回共同祖先 #20 之后的 10 个区块头, 实际 %d 个", len(headers))
+	}
+	if headers := bc.locateHeaders(fork.blockLocator(), bc.Blocks[25].Hash); len(headers) != 5 {
+		t.Errorf("应在 stop 指定的区块处停止, 实际 %d 个", len(headers))
+	}
+}
+
+func TestConnectHeadersChecksProofOfWork(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	remote := bc.withBlocks(append([]Block(nil), bc.Blocks...))
+	var headers []BlockHeader
+	for i := 0; i < 3; i++ {
+		headers = append(headers, mineTestBlock(remote, nil, "Alice", publicKeys).Header)
+	}
+
+	candidate, err := bc.connectHeaders(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidate.Blocks) != 4 || candidate.Blocks[3].Hash != remote.Blocks[3].Hash || !candidate.HasMoreWorkThan(bc) {
+		t.Fatal("区块头链应接在创世区块之后且累计工作量更大")
+	}
+
+	tampered := append([]BlockHeader(nil), headers...)
+	tampered[2].Bits = testParams.PowLimitBits
+	if _, err := bc.connectHeaders(tampered); err == nil {
+		t.Error("难度目标不正确的区块头应被拒绝")
+	}
+	tampered = append([]BlockHeader(nil), headers...)
+	tampered[1].Timestamp++
+	if _, err := bc.connectHeaders(tampered); err == nil {
+		t.Error("修改区块头后下一个区块头的链接应校验失败")
+	}
+}
+
+func TestHeadersFirstSyncReorganizes(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	chdirTemp(t)
+	genesis := bc.Blocks[0]
+	for i := 0; i < 40; i++ {
+		mineTestBlock(bc, nil, "Alice", publicKeys)
+	}
+
+	// 本地节点有一个只含自己区块的分叉，同步时应重组到两个节点共同的主链
+	local := newTestNode(bc.withBlocks([]Block{genesis}), publicKeys)
+	mineTestBlock(local.Blockchain, nil, "Bob", publicKeys)
+	if _, err := local.rebuildBalances(); err != nil {
+		t.Fatal(err)
+	}
+	listenTestNode(t, local)
+	for i := 0; i < 2; i++ {
+		remote := newTestNode(bc.withBlocks(append([]Block(nil), bc.Blocks...)), publicKeys)
+		if err := local.connectPeer(listenTestNode(t, remote)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 对方的链更高时握手后自动请求区块头
+	waitFor(t, "同步到对方的主链", func() bool {
+		local.mu.Lock()
+		defer local.mu.Unlock()
+		return len(local.Blockchain.Blocks) == len(bc.Blocks) && local.Blockchain.Blocks[40].Hash == bc.Blocks[40].Hash
+	})
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	if err := local.Blockchain.ValidateChain(publicKeys); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := local.BalanceManager.GetBalance("Bob"); got != testParams.GenesisAlloc["Bob"] {
+		t.Errorf("重组后 Bob 的余额应为初始余额, 实际 %s", got)
+	}
+	local.download.mu.Lock()
+	defer local.download.mu.Unlock()
+	if local.download.target != nil {
+		t.Error("同步完成后应清除目标链")
+	}
+}
+
+func TestFetchBlocksSpreadsRequestsAcrossPeers(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	for i := 0; i < 40; i++ {
+		mineTestBlock(bc, nil, "Alice", publicKeys)
+	}
+	node := newTestNode(bc.withBlocks(bc.Blocks[:1]), publicKeys)
+	node.peers = make(map[string]*Peer)
+	var peers []*Peer
+	for _, addr := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		conn, other := net.Pipe()
+		t.Cleanup(func() { conn.Close(); other.Close() })
+		p := newPeer(node, conn, addr, false)
+		node.peers[addr] = p
+		peers = append(peers, p)
+	}
+
+	s := &node.download
+	s.setTarget(bc)
+	for _, p := range peers {
+		s.sources[p] = true
+	}
+	node.fetchBlocks()
+	for _, p := range peers {
+		if n := len(s.batches[p]); n != 2 || len(s.batches[p][0]) != maxBlocksPerRequest {
+			t.Fatalf("每个节点应收到两条 getblocks, 实际 %v", s.batches[p])
+		}
+		if len(p.send) != 2 {
+			t.Fatalf("发送队列中应有两条 getblocks, 实际 %d 条", len(p.send))
+		}
+	}
+	if len(s.inFlight) != 40 {
+		t.Fatalf("应请求全部 40 个区块, 实际 %d 个", len(s.inFlight))
+	}
+
+	// 第一个节点没有返回区块，其请求改向第二个节点发送
+	node.handleBlocks(peers[0], BlocksMessage{})
+	node.handleBlocks(peers[0], BlocksMessage{})
+	if s.sources[peers[0]] {
+		t.Error("没有返回请求区块的节点不应再作为下载来源")
+	}
+	for hash, request := range s.inFlight {
+		if request.peer != peers[1] {
+			t.Fatalf("区块 %s 应改向第二个节点请求", hash)
+		}
+	}
+	if len(s.inFlight) != 40 || len(s.batches[peers[1]]) != 4 {
+		t.Errorf("第二个节点应负责全部 40 个区块, 实际 %d 个, %d 条请求", len(s.inFlight), len(s.batches[peers[1]]))
+	}
+}
+
+// remoteConn 以给定地址作为对端地址的连接，使测试中的会话各有不同的对端 IP
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestInvalidDownloadedBlockAbandonsTarget(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	genesis := bc.Blocks[0]
+	mineTestBlock(bc, nil, "Bob", publicKeys)
+	// 区块头链合法，但第 2 个区块中交易的签名不是发送方的签名
+	forged := NewTransaction(testParams.ChainID, "Alice", "Bob", coin.Coin, 0, 1, privateKeys["Bob"])
+	bad := mineTestBlock(bc, []Transaction{forged}, "Bob", publicKeys)
+	chdirTemp(t)
+
+	node := newTestNode(bc.withBlocks([]Block{genesis}), publicKeys)
+	node.peers = make(map[string]*Peer)
+	var peers []*Peer
+	for i, addr := range []string{"10.0.0.1:1", "10.0.0.2:1"} {
+		conn, other := net.Pipe()
+		t.Cleanup(func() { conn.Close(); other.Close() })
+		remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 1}
+		p := newPeer(node, remoteConn{conn, remote}, addr, false)
+		node.peers[addr] = p
+		peers = append(peers, p)
+	}
+	announce := func(p *Peer, blocks []Block) {
+		headers := make([]BlockHeader, len(blocks))
+		for i := range blocks {
+			headers[i] = blocks[i].Header
+		}
+		msg, err := encodeHeaders(headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.handleHeaders(p, msg)
+	}
+	score := func(p *Peer) int {
+		if ban := node.AddrBook.bans[banHost(p.banAddr())]; ban != nil {
+			return ban.BanScore
+		}
+		return 0
+	}
+
+	// 第二个节点只宣告了合法的第 1 个区块，第一个节点宣告了包含无效区块的整条链
+	announce(peers[1], bc.Blocks[1:2])
+	announce(peers[0], bc.Blocks[1:])
+	s := &node.download
+	for _, block := range bc.Blocks[1:] {
+		request, requested := s.inFlight[block.Hash]
+		if !requested {
+			t.Fatalf("区块 #%d 应已请求", block.Header.Index)
+		}
+		node.handleBlocks(request.peer, BlocksMessage{Blocks: []Block{block}})
+	}
+
+	// 区块与区块头一致却校验失败：放弃目标链，只惩罚宣告了无效区块头的节点
+	if s.target != nil || !s.invalid[bad.Hash] {
+		t.Fatal("无效区块应使同步放弃目标链并被记住")
+	}
+	if score(peers[0]) == 0 || score(peers[1]) != 0 {
+		t.Errorf("只应惩罚宣告无效区块头的节点, 惩罚分 %d, %d", score(peers[0]), score(peers[1]))
+	}
+	if node.Blockchain.FindBlock(bad.Hash) >= 0 {
+		t.Error("无效区块不应接入主链")
+	}
+
+	// 之后再宣告包含该区块的区块头链不会再成为目标链
+	announce(peers[1], bc.Blocks[node.Blockchain.FindBlock(bad.Header.PreviousHash)+1:])
+	if s.target != nil || score(peers[1]) == 0 {
+		t.Error("包含已知无效区块的区块头链不应成为目标链，宣告的节点应被惩罚")
+	}
+}
+
+// appendTestHeaders 在链尾追加 n 个只有区块头的区块，时间戳按目标出块间隔递增
+func appendTestHeaders(bc *Blockchain, n int) {
+	for i := 0; i < n; i++ {
+		prev := bc.Blocks[len(bc.Blocks)-1]
+		block := Block{Header: BlockHeader{
+			Version:      currentBlockVersion,
+			Index:        prev.Header.Index + 1,
+			Timestamp:    prev.Header.Timestamp + bc.Params.TargetBlockTime,
+			PreviousHash: prev.Hash,
+			MerkleRoot:   zeroHash,
+			StateRoot:    zeroHash,
+			Bits:         bc.NextBits(),
+		}}
+		block.ProofOfWork()
+		bc.Blocks = append(bc.Blocks, block)
+	}
+}
+
+func TestDeepForkSyncsAcrossHeaderBatches(t *testing.T) {
+	bc, _, publicKeys := newTestChain(t)
+	genesis := bc.Blocks[0]
+	appendTestHeaders(bc, maxHeadersPerMessage+1000)
+
+	// 本地链只有一个难度很高的区块，对方的分叉要超过 maxHeadersPerMessage 个区块后累计工作量才更大
+	heavy := Block{Header: BlockHeader{Version: currentBlockVersion, Index: 1, PreviousHash: genesis.Hash, Bits: 0x1f017fff}}
+	heavy.Hash = heavy.CalculateHash()
+	node := newTestNode(bc.withBlocks([]Block{genesis, heavy}), publicKeys)
+	if bc.withBlocks(bc.Blocks[:maxHeadersPerMessage+1]).HasMoreWorkThan(node.Blockchain) || !bc.HasMoreWorkThan(node.Blockchain) {
+		t.Fatal("测试链的累计工作量设置不正确")
+	}
+	conn, other := net.Pipe()
+	t.Cleanup(func() { conn.Close(); other.Close() })
+	p := newPeer(node, conn, "127.0.0.1:1", false)
+	node.peers = map[string]*Peer{p.Addr: p}
+
+	headers := func(blocks []Block) HeadersMessage {
+		list := make([]BlockHeader, len(blocks))
+		for i := range blocks {
+			list[i] = blocks[i].Header
+		}
+		msg, err := encodeHeaders(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	node.handleHeaders(p, headers(bc.Blocks[1:maxHeadersPerMessage+1]))
+	if node.download.target != nil || len(p.send) != 1 {
+		t.Fatalf("满额但工作量不足的区块头不应成为目标链，应继续请求后续区块头, 发送 %d 条", len(p.send))
+	}
+	if msg, ok := (<-p.send).(GetHeadersMessage); !ok || msg.Locator[0] != bc.Blocks[maxHeadersPerMessage].Hash {
+		t.Fatalf("应从上一批区块头的链尾继续请求: %+v", msg)
+	}
+
+	node.handleHeaders(p, headers(bc.Blocks[maxHeadersPerMessage+1:]))
+	if target := node.download.target; target == nil || len(target.Blocks) != len(bc.Blocks) {
+		t.Fatal("后续区块头到达后累计工作量更大的分叉应成为目标链")
+	}
+}
@@ -2,68 +2,270 @@ package main
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"gamechain/coin"
 	"math/big"
 )
 
-// 处理新交易
-func (node *Node) handleTransaction(request map[string]interface{}) {
-	var tx Transaction
-	if err := mapToStruct(request["transaction"], &tx); err != nil {
-		fmt.Printf("交易解析失败: %v\n", err)
-		return
-	}
-	node.HandleNewTransaction(tx)
+// transactionPoolFile 返回节点保存交易池的文件
+func (node *Node) transactionPoolFile() string {
+	return fmt.Sprintf("%s_transaction_pool.json", node.Address)
 }
 
-// 添加新交易到交易池
-func (node *Node) HandleNewTransaction(tx Transaction) {
-	filePath := fmt.Sprintf("%s_transaction_pool.json", node.Address)
-	if node.Blockchain.AddTransactionToPool(tx, node.PublicKeys, filePath) {
-		fmt.Printf("交易已添加到交易池: %+v\n", tx)
-	} else {
+// HandleNewTransaction 添加新交易到交易池，返回加入交易池的交易。nonce 超前的交易先暂存，
+// 交易加入后，同一发送方暂存的后续交易随之依次加入
+func (node *Node) HandleNewTransaction(tx Transaction) []Transaction {
+	filePath := node.transactionPoolFile()
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if !tx.IsUTXO() && tx.Nonce > node.Blockchain.NextNonce(tx.Sender) {
+		node.holdFutureTx(tx)
+		return nil
+	}
+	if !node.Blockchain.AddTransactionToPool(tx, node.PublicKeys, filePath) {
 		fmt.Printf("交易验证失败: %+v\n", tx)
+		return nil
 	}
+	fmt.Printf("交易已添加到交易池: %+v\n", tx)
+	return append([]Transaction{tx}, node.promoteFutureTxs(tx.Sender, filePath)...)
 }
 
+// 交易格式版本。旧格式交易的签名数据是字段直接拼接的字符串，只出现在旧格式区块中；
+// UTXO 交易只出现在启用 UTXO 模式的网络中，批量转账交易只出现在账户模式的网络中
+const (
+	legacyTxVersion  = 0
+	currentTxVersion = 1
+	utxoTxVersion    = 2
+	batchTxVersion   = 3
+)
+
+// txSigningDomain 是交易签名数据的域分隔符，避免交易签名被当作其他类型的数据签名使用
+const txSigningDomain = "gamechain/tx"
+
 type Transaction struct {
+	Version   uint32 // 交易格式版本，0 为旧格式
 	Sender    string
 	Receiver  string
-	Amount    float64
+	Amount    coin.Amount
+	Fee       coin.Amount // 支付给打包该交易的矿工的手续费，旧格式交易和奖励交易为 0
+	Nonce     uint64      // 发送方的交易序号，从 1 开始逐笔加一；奖励交易为区块高度，旧格式交易和 UTXO 交易为 0
 	Signature string
+
+	Inputs  []OutPoint `json:",omitempty"` // UTXO 交易花费的输出，均属于发送方
+	Outputs []TxOutput `json:",omitempty"` // UTXO 交易和批量转账交易的输出，替代 Receiver 和 Amount
+}
+
+// TxOutput 交易的一个输出
+type TxOutput struct {
+	Receiver string
+	Amount   coin.Amount
+}
+
+// IsLegacy 判断交易是否为旧格式
+func (tx *Transaction) IsLegacy() bool {
+	return tx.Version == legacyTxVersion
+}
+
+// IsUTXO 判断交易是否为花费输入、创建多个输出的 UTXO 交易
+func (tx *Transaction) IsUTXO() bool {
+	return tx.Version == utxoTxVersion
+}
+
+// IsBatch 判断交易是否为一次签名向多个接收方付款的批量转账交易
+func (tx *Transaction) IsBatch() bool {
+	return tx.Version == batchTxVersion
+}
+
+// hasOutputs 判断交易是否以 Outputs 列出接收方
+func (tx *Transaction) hasOutputs() bool {
+	return tx.IsUTXO() || tx.IsBatch()
+}
+
+// outputs 返回交易创建的输出。只有一个接收方的交易视为只有一个输出
+func (tx *Transaction) outputs() []TxOutput {
+	if tx.hasOutputs() {
+		return tx.Outputs
+	}
+	return []TxOutput{{Receiver: tx.Receiver, Amount: tx.Amount}}
+}
+
+// checkOutputs 检查以 Outputs 列出接收方的交易的格式：不使用 Receiver 和 Amount，
+// 输出数在 1 到 maxTxOutputs 之间，每个输出的接收方非空且金额为正
+func (tx *Transaction) checkOutputs() error {
+	if tx.Receiver != "" || tx.Amount != 0 {
+		return fmt.Errorf("多输出交易不能设置 Receiver 或 Amount")
+	}
+	if len(tx.Outputs) == 0 || len(tx.Outputs) > maxTxOutputs {
+		return fmt.Errorf("交易输出数 %d 不在 1 到 %d 之间", len(tx.Outputs), maxTxOutputs)
+	}
+	for _, out := range tx.Outputs {
+		if out.Receiver == "" || out.Receiver == "System" || out.Amount <= 0 {
+			return fmt.Errorf("交易输出无效: %q %s", out.Receiver, out.Amount)
+		}
+	}
+	return nil
+}
+
+// encodeBody 返回交易主体（不含签名）的规范二进制编码，整数均为大端序：
+// Version(4) | len(Sender)(4) | Sender | len(Receiver)(4) | Receiver | Amount(8) | Fee(8) | Nonce(8)。
+// UTXO 交易和批量转账交易为 Version(4) | len(Sender)(4) | Sender | Fee(8) | Nonce(8) | 输入数(4) | 输入... | 输出数(4) | 输出...，
+// 每个输入为 len(TxID)(4) | TxID | Index(4)，每个输出为 len(Receiver)(4) | Receiver | Amount(8)
+func (tx *Transaction) encodeBody() []byte {
+	if tx.hasOutputs() {
+		return tx.encodeOutputsBody()
+	}
+	buf := make([]byte, 0, 4+4+len(tx.Sender)+4+len(tx.Receiver)+8+8+8)
+	buf = binary.BigEndian.AppendUint32(buf, tx.Version)
+	buf = appendLengthPrefixed(buf, tx.Sender)
+	buf = appendLengthPrefixed(buf, tx.Receiver)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Amount))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Fee))
+	buf = binary.BigEndian.AppendUint64(buf, tx.Nonce)
+	return buf
+}
+
+func (tx *Transaction) encodeOutputsBody() []byte {
+	buf := binary.BigEndian.AppendUint32(nil, tx.Version)
+	buf = appendLengthPrefixed(buf, tx.Sender)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(tx.Fee))
+	buf = binary.BigEndian.AppendUint64(buf, tx.Nonce)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		buf = appendLengthPrefixed(buf, in.TxID)
+		buf = binary.BigEndian.AppendUint32(buf, in.Index)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		buf = appendLengthPrefixed(buf, out.Receiver)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(out.Amount))
+	}
+	return buf
+}
+
+// appendLengthPrefixed 追加 4 字节大端序长度前缀和字符串内容
+func appendLengthPrefixed(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// Size 返回交易的字节数，即交易主体编码加上签名的长度，用于计算手续费率和限制区块大小
+func (tx *Transaction) Size() int {
+	return len(tx.encodeBody()) + len(tx.Signature)/2
+}
+
+// Cost 返回发送方需要支付的总金额，即全部输出金额加手续费
+func (tx *Transaction) Cost() (coin.Amount, error) {
+	cost := tx.Fee
+	for _, out := range tx.outputs() {
+		var err error
+		if cost, err = cost.Add(out.Amount); err != nil {
+			return 0, err
+		}
+	}
+	return cost, nil
+}
+
+// signingHash 返回交易签名所针对的哈希。
+// 新格式为 sha256(域分隔符 | 链 ID | 交易主体)，不同网络上的签名互不通用；旧格式沿用字段拼接的字符串
+func (tx *Transaction) signingHash(chainID string) [32]byte {
+	if tx.IsLegacy() {
+		return sha256.Sum256([]byte(fmt.Sprintf("%s%s%f", tx.Sender, tx.Receiver, tx.Amount.Float64())))
+	}
+	buf := appendLengthPrefixed(nil, txSigningDomain)
+	buf = appendLengthPrefixed(buf, chainID)
+	return sha256.Sum256(append(buf, tx.encodeBody()...))
+}
+
+// ID 返回交易的唯一标识，用于交易池去重和交易查询。
+// 新格式交易的 ID 为交易主体编码的哈希，不随重新签名变化；旧格式交易沿用包含签名的字符串哈希
+func (tx *Transaction) ID() string {
+	var hash [32]byte
+	if tx.IsLegacy() {
+		hash = sha256.Sum256([]byte(fmt.Sprintf("%s%s%f%s", tx.Sender, tx.Receiver, tx.Amount.Float64(), tx.Signature)))
+	} else {
+		hash = sha256.Sum256(tx.encodeBody())
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+// merkleLeaf 返回交易在区块 Merkle 树中的叶子，使区块头同时承诺交易签名。
+// 新格式为 sha256(交易主体 | len(Signature)(4) | Signature)；旧格式交易的 ID 已包含签名，直接使用 ID
+func (tx *Transaction) merkleLeaf() string {
+	if tx.IsLegacy() {
+		return tx.ID()
+	}
+	hash := sha256.Sum256(appendLengthPrefixed(tx.encodeBody(), tx.Signature))
+	return hex.EncodeToString(hash[:])
 }
 
 // 创建新交易
-func NewTransaction(sender, receiver string, amount float64, privateKey *ecdsa.PrivateKey) Transaction {
+func NewTransaction(chainID, sender, receiver string, amount, fee coin.Amount, nonce uint64, privateKey *ecdsa.PrivateKey) Transaction {
 	tx := Transaction{
+		Version:  currentTxVersion,
 		Sender:   sender,
 		Receiver: receiver,
 		Amount:   amount,
+		Fee:      fee,
+		Nonce:    nonce,
 	}
 	if privateKey != nil {
-		SignTransaction(&tx, privateKey)
+		if err := SignTransaction(&tx, chainID, privateKey); err != nil {
+			fmt.Printf("交易签名失败: %v\n", err)
+		}
 	}
 	return tx
 }
 
-// 签名交易
-func SignTransaction(tx *Transaction, privateKey *ecdsa.PrivateKey) {
-	txData := fmt.Sprintf("%s%s%f", tx.Sender, tx.Receiver, tx.Amount)
-	hash := sha256.Sum256([]byte(txData))
-	r, s, _ := ecdsa.Sign(rand.Reader, privateKey, hash[:])
-	tx.Signature = fmt.Sprintf("%s:%s", r.String(), s.String())
+// NewBatchTransaction 创建一次签名向多个接收方付款的批量转账交易
+func NewBatchTransaction(chainID, sender string, outputs []TxOutput, fee coin.Amount, nonce uint64, privateKey *ecdsa.PrivateKey) (Transaction, error) {
+	tx := Transaction{
+		Version: batchTxVersion,
+		Sender:  sender,
+		Fee:     fee,
+		Nonce:   nonce,
+		Outputs: outputs,
+	}
+	if err := tx.checkOutputs(); err != nil {
+		return Transaction{}, err
+	}
+	if privateKey == nil {
+		return Transaction{}, fmt.Errorf("账户 %s 的私钥不存在", sender)
+	}
+	if err := SignTransaction(&tx, chainID, privateKey); err != nil {
+		return Transaction{}, err
+	}
+	return tx, nil
 }
 
-// 验证交易
-func VerifyTransaction(tx *Transaction, publicKey *ecdsa.PublicKey) bool {
-	txData := fmt.Sprintf("%s%s%f", tx.Sender, tx.Receiver, tx.Amount)
-	hash := sha256.Sum256([]byte(txData))
-	var r, s big.Int
-	n, err := fmt.Sscanf(tx.Signature, "%s:%s", &r, &s)
-	if err != nil || n != 2 {
+// SignTransaction 使用私钥签名新格式交易
+func SignTransaction(tx *Transaction, chainID string, privateKey *ecdsa.PrivateKey) error {
+	if tx.IsLegacy() {
+		return fmt.Errorf("不能再签名旧格式交易")
+	}
+	hash := tx.signingHash(chainID)
+	signature, err := signHash(privateKey, hash[:])
+	if err != nil {
+		return err
+	}
+	tx.Signature = signature
+	return nil
+}
+
+// VerifyTransaction 验证交易签名，旧格式交易使用旧的签名格式
+func VerifyTransaction(tx *Transaction, chainID string, publicKey *ecdsa.PublicKey) bool {
+	var r, s *big.Int
+	var err error
+	if tx.IsLegacy() {
+		r, s, err = parseLegacySignature(tx.Signature)
+	} else {
+		r, s, err = parseSignature(tx.Signature, publicKey)
+	}
+	if err != nil {
 		return false
 	}
-	return ecdsa.Verify(publicKey, hash[:], &r, &s)
+	hash := tx.signingHash(chainID)
+	return ecdsa.Verify(publicKey, hash[:], r, s)
 }
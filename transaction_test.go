@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"gamechain/coin"
+	"gamechain/wire"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionEncodingSeparatesFields(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b Transaction
+	}{
+		{
+			"发送方与接收方边界",
+			Transaction{Version: currentTxVersion, Sender: "Al", Receiver: "iceBob", Amount: 1, Nonce: 1},
+			Transaction{Version: currentTxVersion, Sender: "Ali", Receiver: "ceBob", Amount: 1, Nonce: 1},
+		},
+		{
+			"相差一个最小单位的金额",
+			Transaction{Version: currentTxVersion, Sender: "Alice", Receiver: "Bob", Amount: 1, Nonce: 1},
+			Transaction{Version: currentTxVersion, Sender: "Alice", Receiver: "Bob", Amount: 2, Nonce: 1},
+		},
+		{
+			"批量转账的输出边界",
+			Transaction{Version: batchTxVersion, Sender: "Alice", Nonce: 1, Outputs: []TxOutput{{"Bob", 1}, {"Carol", 2}}},
+			Transaction{Version: batchTxVersion, Sender: "Alice", Nonce: 1, Outputs: []TxOutput{{"Bob", 1}, {"Carol", 3}}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.a.ID() == c.b.ID() {
+				t.Error("不同交易的 ID 不应相同")
+			}
+			if c.a.signingHash(testParams.ChainID) == c.b.signingHash(testParams.ChainID) {
+				t.Error("不同交易的签名数据不应相同")
+			}
+		})
+	}
+}
+
+func TestTransactionSignatureBoundToChainID(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := NewTransaction("gamechain-test", "Alice", "Bob", 10*coin.Coin, 0, 1, privateKey)
+	if !VerifyTransaction(&tx, "gamechain-test", &privateKey.PublicKey) {
+		t.Fatal("交易应在签名时的网络上通过验证")
+	}
+	if VerifyTransaction(&tx, "gamechain-main", &privateKey.PublicKey) {
+		t.Error("测试网络的签名不应在主网络上通过验证")
+	}
+
+	resigned := tx
+	if err := SignTransaction(&resigned, "gamechain-main", privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if resigned.ID() != tx.ID() {
+		t.Error("重新签名不应改变交易 ID")
+	}
+	// 区块头通过 Merkle 根承诺签名，替换签名后区块与区块头不再一致
+	if CalculateMerkleRoot([]Transaction{resigned}) == CalculateMerkleRoot([]Transaction{tx}) {
+		t.Error("Merkle 根应包含交易签名")
+	}
+}
+
+func TestBatchTransactionPaysAllOutputs(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	outputs := []TxOutput{{"Bob", 10 * coin.Coin}, {"Carol", 5 * coin.Coin}, {"Bob", 2 * coin.Coin}}
+	tx, err := NewBatchTransaction(testParams.ChainID, "Alice", outputs, coin.Coin, 1, privateKeys["Alice"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bc.AddTransactionToPool(tx, publicKeys, t.TempDir()+"/pool.json") {
+		t.Fatal("批量转账交易应被接受")
+	}
+	mineTestBlock(bc, bc.TransactionPool, "Alice", publicKeys)
+	if err := bc.ValidateChain(publicKeys); err != nil {
+		t.Fatalf("包含批量转账的链校验失败: %v", err)
+	}
+
+	l := bc.chainLedger()
+	if l.balances["Bob"] != 112*coin.Coin || l.balances["Carol"] != 5*coin.Coin || l.nonces["Alice"] != 1 {
+		t.Errorf("批量转账执行结果不正确: %v %v", l.balances, l.nonces)
+	}
+	cached, err := bc.chainBalances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, balance := range l.balances {
+		if got, _ := cached.GetBalance(name); name != "System" && got != balance {
+			t.Errorf("余额缓存中 %s 的余额 %s 与账本 %s 不一致", name, got, balance)
+		}
+	}
+
+	// 输出被篡改后签名失效
+	bc.Blocks[1].Transactions[0].Outputs[1].Amount = 50 * coin.Coin
+	if VerifyTransaction(&bc.Blocks[1].Transactions[0], testParams.ChainID, publicKeys["Alice"]) {
+		t.Error("输出被篡改的交易签名应无效")
+	}
+}
+
+func TestBatchTransactionRejectsInvalidOutputs(t *testing.T) {
+	_, privateKeys, _ := newTestChain(t)
+	for name, outputs := range map[string][]TxOutput{
+		"没有输出":    nil,
+		"金额为零":    {{"Bob", 0}},
+		"接收方为空":   {{"", coin.Coin}},
+		"向系统账户付款": {{"System", coin.Coin}},
+	} {
+		if _, err := NewBatchTransaction(testParams.ChainID, "Alice", outputs, 0, 1, privateKeys["Alice"]); err == nil {
+			t.Errorf("%s: 期望返回错误", name)
+		}
+	}
+}
+
+func TestBatchTransactionSurvivesWireEncoding(t *testing.T) {
+	_, privateKeys, publicKeys := newTestChain(t)
+	tx, err := NewBatchTransaction(testParams.ChainID, "Alice", []TxOutput{{"Bob", coin.Coin / 3}, {"Carol", 7}}, 1, 1, privateKeys["Alice"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与节点之间传输相同，交易经消息帧编码后再解码
+	var buf bytes.Buffer
+	if err := wire.Encode(&buf, testParams.Magic(), protocolVersion, CommandTx, TxMessage{Transaction: tx}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wire.ReadMessage(&buf, testParams.Magic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded TxMessage
+	if err := msg.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	received := decoded.Transaction
+	if !reflect.DeepEqual(received, tx) || !VerifyTransaction(&received, testParams.ChainID, publicKeys["Alice"]) {
+		t.Errorf("经网络传输后交易发生变化: %+v", received)
+	}
+}
+
+func TestReadBatchOutputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prizes.csv")
+	content := "receiver,amount\n# 第一名\nBob, 10.5\nCarol,0.00000001\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := readBatchOutputs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TxOutput{{"Bob", 10*coin.Coin + coin.Coin/2}, {"Carol", 1}}
+	if !reflect.DeepEqual(outputs, want) {
+		t.Errorf("期望 %v, 实际 %v", want, outputs)
+	}
+}
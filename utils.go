@@ -3,7 +3,6 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"strings"
 )
 
@@ -15,11 +14,13 @@ func CalculateMerkleRoot(transactions []Transaction) string {
 
 	hashes := []string{}
 	for _, tx := range transactions {
-		txData := fmt.Sprintf("%s%s%f%s", tx.Sender, tx.Receiver, tx.Amount, tx.Signature)
-		hash := sha256.Sum256([]byte(txData))
-		hashes = append(hashes, hex.EncodeToString(hash[:]))
+		hashes = append(hashes, tx.merkleLeaf())
 	}
+	return merkleRoot(hashes)
+}
 
+// merkleRoot 两两合并十六进制哈希直到只剩一个，奇数个时最后一个直接进入上一层
+func merkleRoot(hashes []string) string {
 	for len(hashes) > 1 {
 		var nextLevel []string
 		for i := 0; i < len(hashes); i += 2 {
@@ -36,10 +37,16 @@ func CalculateMerkleRoot(transactions []Transaction) string {
 	return hashes[0]
 }
 
-// 解析节点地址
+// 解析逗号分隔的节点地址，忽略空白和重复的地址
 func ParsePeers(peers string) []string {
-	if peers == "" {
-		return []string{}
+	addrs := []string{}
+	seen := make(map[string]bool)
+	for _, addr := range strings.Split(peers, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
 	}
-	return strings.Split(peers, ",")
+	return addrs
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"gamechain/coin"
+	"sort"
+)
+
+// OutPoint 引用某笔交易的第 Index 个输出
+type OutPoint struct {
+	TxID  string
+	Index uint32
+}
+
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%s:%d", o.TxID, o.Index)
+}
+
+// UTXO 尚未被花费的交易输出
+type UTXO struct {
+	TxOutput
+	Height   int  // 创建该输出的区块高度
+	Coinbase bool // 是否为奖励交易的输出，奖励需要成熟后才能花费
+}
+
+// spentOutput 区块花费的输出，断开区块时用于恢复 UTXO 集合
+type spentOutput struct {
+	OutPoint
+	UTXO
+}
+
+// UTXOSet 主链上尚未花费的输出集合。连接区块时花费输入、创建输出，断开区块时按记录的花费恢复
+type UTXOSet struct {
+	unspent  map[OutPoint]UTXO
+	undo     map[string][][]spentOutput // 区块哈希 -> 该区块中每笔交易花费的输出
+	maturity int                        // 奖励输出需要经过的区块数
+}
+
+// NewUTXOSet 创建空的 UTXO 集合，maturity 为奖励输出成熟需要的区块数
+func NewUTXOSet(maturity int) *UTXOSet {
+	return &UTXOSet{
+		unspent:  make(map[OutPoint]UTXO),
+		undo:     make(map[string][][]spentOutput),
+		maturity: maturity,
+	}
+}
+
+// checkInputs 检查 UTXO 交易在高度 height 能否执行：交易格式正确，输入存在、未被花费、属于发送方且已成熟，
+// 输入总额等于输出总额加手续费。不修改集合
+func (s *UTXOSet) checkInputs(tx *Transaction, height int) error {
+	if tx.Nonce != 0 || len(tx.Inputs) == 0 {
+		return fmt.Errorf("UTXO 交易不能设置 nonce，且至少需要一个输入")
+	}
+	if err := tx.checkOutputs(); err != nil {
+		return err
+	}
+
+	seen := make(map[OutPoint]bool)
+	var total coin.Amount
+	for _, in := range tx.Inputs {
+		if seen[in] {
+			return fmt.Errorf("输入 %s 被重复花费", in)
+		}
+		seen[in] = true
+		utxo, exists := s.unspent[in]
+		if !exists {
+			return fmt.Errorf("输入 %s 不存在或已被花费", in)
+		}
+		if utxo.Receiver != tx.Sender {
+			return fmt.Errorf("输入 %s 属于 %s, 不属于发送方 %s", in, utxo.Receiver, tx.Sender)
+		}
+		if utxo.Coinbase && utxo.Height+s.maturity > height {
+			return fmt.Errorf("输入 %s 是未成熟的奖励", in)
+		}
+		var err error
+		if total, err = total.Add(utxo.Amount); err != nil {
+			return fmt.Errorf("输入总额溢出: %w", err)
+		}
+	}
+	cost, err := tx.Cost()
+	if err != nil {
+		return fmt.Errorf("交易金额溢出: %w", err)
+	}
+	if total != cost {
+		return fmt.Errorf("输入总额 %s 不等于输出总额加手续费 %s", total, cost)
+	}
+	return nil
+}
+
+// applyTransaction 在高度 height 执行交易：花费 UTXO 交易的输入并创建输出，返回被花费的输出。
+// 调用方需先通过 checkInputs 检查 UTXO 交易
+func (s *UTXOSet) applyTransaction(tx *Transaction, height int, coinbase bool) []spentOutput {
+	var spent []spentOutput
+	if tx.IsUTXO() {
+		for _, in := range tx.Inputs {
+			spent = append(spent, spentOutput{in, s.unspent[in]})
+			delete(s.unspent, in)
+		}
+	}
+	id := tx.ID()
+	for i, out := range tx.outputs() {
+		// 系统账户不持有输出
+		if out.Receiver == "System" || out.Amount <= 0 {
+			continue
+		}
+		s.unspent[OutPoint{id, uint32(i)}] = UTXO{TxOutput: out, Height: height, Coinbase: coinbase}
+	}
+	return spent
+}
+
+// revertTransaction 撤销 applyTransaction：删除交易创建的输出并恢复被花费的输出
+func (s *UTXOSet) revertTransaction(tx *Transaction, spent []spentOutput) {
+	id := tx.ID()
+	for i := range tx.outputs() {
+		delete(s.unspent, OutPoint{id, uint32(i)})
+	}
+	for _, out := range spent {
+		s.unspent[out.OutPoint] = out.UTXO
+	}
+}
+
+// ConnectBlock 按顺序执行接入主链的区块中的交易，任一交易无效时返回错误且不改变集合
+func (s *UTXOSet) ConnectBlock(block *Block) error {
+	height := block.Header.Index
+	undo := make([][]spentOutput, 0, len(block.Transactions))
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if tx.Sender != "System" {
+			err := fmt.Errorf("UTXO 模式只接受 UTXO 交易: %s", tx.ID())
+			if tx.IsUTXO() {
+				err = s.checkInputs(tx, height)
+			}
+			if err != nil {
+				s.revertTransactions(block.Transactions[:i], undo)
+				return err
+			}
+		}
+		coinbase := !block.Header.IsLegacy() && i == len(block.Transactions)-1
+		undo = append(undo, s.applyTransaction(tx, height, coinbase))
+	}
+	s.undo[block.Hash] = undo
+	return nil
+}
+
+// revertTransactions 按相反顺序撤销一组交易，spent[i] 为第 i 笔交易花费的输出
+func (s *UTXOSet) revertTransactions(transactions []Transaction, spent [][]spentOutput) {
+	for i := len(transactions) - 1; i >= 0; i-- {
+		s.revertTransaction(&transactions[i], spent[i])
+	}
+}
+
+// DisconnectBlock 按相反顺序撤销区块中的交易，区块必须是最近接入且尚未断开的区块
+func (s *UTXOSet) DisconnectBlock(block *Block) error {
+	undo, exists := s.undo[block.Hash]
+	if !exists {
+		return fmt.Errorf("缺少区块 #%d 的花费记录", block.Header.Index)
+	}
+	s.revertTransactions(block.Transactions, undo)
+	delete(s.undo, block.Hash)
+	return nil
+}
+
+// Unspent 返回属于 owner 的全部未花费输出，按创建高度和输出位置排序
+func (s *UTXOSet) Unspent(owner string) ([]OutPoint, []UTXO) {
+	var points []OutPoint
+	for point, utxo := range s.unspent {
+		if utxo.Receiver == owner {
+			points = append(points, point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		a, b := s.unspent[points[i]], s.unspent[points[j]]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if points[i].TxID != points[j].TxID {
+			return points[i].TxID < points[j].TxID
+		}
+		return points[i].Index < points[j].Index
+	})
+	utxos := make([]UTXO, len(points))
+	for i, point := range points {
+		utxos[i] = s.unspent[point]
+	}
+	return points, utxos
+}
+
+// Spendable 判断输出在高度 height 是否可以花费
+func (s *UTXOSet) Spendable(utxo UTXO, height int) bool {
+	return !utxo.Coinbase || utxo.Height+s.maturity <= height
+}
+
+// chainUTXOs 从创世区块开始依次接入主链上的区块，得到 UTXO 集合
+func (bc *Blockchain) chainUTXOs() (*UTXOSet, error) {
+	utxos := NewUTXOSet(bc.Params.CoinbaseMaturity)
+	for i := range bc.Blocks {
+		if err := utxos.ConnectBlock(&bc.Blocks[i]); err != nil {
+			return nil, fmt.Errorf("区块 #%d: %w", bc.Blocks[i].Header.Index, err)
+		}
+	}
+	return utxos, nil
+}
+
+// NewUTXOTransfer 从发送方可花费的输出中按创建顺序选取输入，创建向 outputs 付款的 UTXO 交易，
+// 找零输出返回发送方。交易池中的交易已花费的输出不会被选取，其找零输出可以继续花费
+func (bc *Blockchain) NewUTXOTransfer(sender string, outputs []TxOutput, fee coin.Amount, privateKey *ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) (Transaction, error) {
+	cost, err := (&Transaction{Version: utxoTxVersion, Fee: fee, Outputs: outputs}).Cost()
+	if err != nil {
+		return Transaction{}, fmt.Errorf("交易金额溢出: %w", err)
+	}
+	l := bc.pendingLedger()
+	points, utxos := l.utxos.Unspent(sender)
+	tx := Transaction{Version: utxoTxVersion, Sender: sender, Fee: fee}
+	var total coin.Amount
+	for i, utxo := range utxos {
+		if total >= cost {
+			break
+		}
+		if l.utxos.Spendable(utxo, l.height) {
+			tx.Inputs = append(tx.Inputs, points[i])
+			total += utxo.Amount
+		}
+	}
+	if total < cost {
+		return Transaction{}, fmt.Errorf("账户 %s 可花费的输出不足 (可用: %s, 需要: %s)", sender, total, cost)
+	}
+	tx.Outputs = append([]TxOutput{}, outputs...)
+	if change := total - cost; change > 0 {
+		tx.Outputs = append(tx.Outputs, TxOutput{Receiver: sender, Amount: change})
+	}
+	if privateKey == nil {
+		return Transaction{}, fmt.Errorf("账户 %s 的私钥不存在", sender)
+	}
+	if err := SignTransaction(&tx, bc.Params.ChainID, privateKey); err != nil {
+		return Transaction{}, err
+	}
+	return tx, nil
+}
+
+// printInputsOutputs 打印 UTXO 交易和批量转账交易的输入和输出，每行以 indent 开头
+func printInputsOutputs(tx *Transaction, indent string) {
+	if !tx.hasOutputs() {
+		return
+	}
+	for _, in := range tx.Inputs {
+		fmt.Printf("%s输入: %s\n", indent, in)
+	}
+	for i, out := range tx.Outputs {
+		fmt.Printf("%s输出 #%d: %s %s\n", indent, i, out.Receiver, out.Amount)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"gamechain/coin"
+	"reflect"
+	"testing"
+)
+
+// newUTXOTestChain 生成启用 UTXO 模式的测试链，账户与预分配余额同 newTestChain
+func newUTXOTestChain(t *testing.T) (*Blockchain, *ChainParams) {
+	t.Helper()
+	params := *testParams
+	params.UTXO = true
+	return &Blockchain{Params: &params, Blocks: []Block{newGenesisBlock(&params)}}, &params
+}
+
+func TestUTXOTransferAndMempoolDoubleSpend(t *testing.T) {
+	_, privateKeys, publicKeys := newTestChain(t)
+	bc, params := newUTXOTestChain(t)
+	poolFile := t.TempDir() + "/pool.json"
+
+	tx, err := bc.NewUTXOTransfer("Alice", []TxOutput{{"Bob", 30 * coin.Coin}}, coin.Coin, privateKeys["Alice"], publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 1 || len(tx.Outputs) != 2 || tx.Outputs[1].Amount != 69*coin.Coin {
+		t.Fatalf("应花费预分配输出并找零 69: %+v", tx)
+	}
+	if !bc.AddTransactionToPool(tx, publicKeys, poolFile) {
+		t.Fatal("UTXO 交易应被接受")
+	}
+
+	// 花费同一输入的另一笔交易是双花
+	doubleSpend := Transaction{Version: utxoTxVersion, Sender: "Alice", Inputs: tx.Inputs, Outputs: []TxOutput{{"Alice", 100 * coin.Coin}}}
+	SignTransaction(&doubleSpend, params.ChainID, privateKeys["Alice"])
+	if bc.AddTransactionToPool(doubleSpend, publicKeys, poolFile) {
+		t.Error("交易池中的双花交易应被拒绝")
+	}
+	// 账户模式的交易不能进入 UTXO 模式的交易池
+	if bc.AddTransactionToPool(NewTransaction(params.ChainID, "Bob", "Alice", coin.Coin, 0, 1, privateKeys["Bob"]), publicKeys, poolFile) {
+		t.Error("UTXO 模式下账户模式的交易应被拒绝")
+	}
+
+	mineTestBlock(bc, bc.TransactionPool, "Bob", publicKeys)
+	if err := bc.ValidateChain(publicKeys); err != nil {
+		t.Fatalf("UTXO 链校验失败: %v", err)
+	}
+	utxos, err := bc.chainUTXOs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bobs := utxos.Unspent("Bob")
+	coinbases := 0
+	for _, utxo := range bobs {
+		if utxo.Coinbase {
+			coinbases++
+		}
+	}
+	if len(bobs) != 3 || coinbases != 1 {
+		t.Errorf("Bob 应有预分配、转账和奖励三个输出: %+v", bobs)
+	}
+	if l := bc.chainLedger(); l.balances["Alice"] != 69*coin.Coin || l.balances["Bob"] != 130*coin.Coin+testParams.InitialSubsidy+coin.Coin {
+		t.Errorf("账户余额应与输出一致: %v", l.balances)
+	}
+
+	// 已上链的输入不能再次花费
+	bc.TransactionPool = nil
+	if bc.AddTransactionToPool(tx, publicKeys, poolFile) {
+		t.Error("输入已被花费的交易应被拒绝")
+	}
+}
+
+func TestUTXOSetDisconnectRestoresOutputs(t *testing.T) {
+	_, privateKeys, publicKeys := newTestChain(t)
+	bc, _ := newUTXOTestChain(t)
+	utxos, err := bc.chainUTXOs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := utxos.Unspent("Alice")
+
+	// 同一区块中的第二笔交易花费第一笔交易的找零
+	first, _ := bc.NewUTXOTransfer("Alice", []TxOutput{{"Bob", 10 * coin.Coin}}, 0, privateKeys["Alice"], publicKeys)
+	bc.TransactionPool = []Transaction{first}
+	second, err := bc.NewUTXOTransfer("Alice", []TxOutput{{"Bob", 80 * coin.Coin}}, 0, privateKeys["Alice"], publicKeys)
+	if err != nil || second.Inputs[0].TxID != first.ID() {
+		t.Fatalf("第二笔交易应花费交易池中的找零: %+v, %v", second, err)
+	}
+	block := mineTestBlock(bc, []Transaction{first, second}, "Bob", publicKeys)
+
+	if err := utxos.ConnectBlock(&block); err != nil {
+		t.Fatal(err)
+	}
+	if points, _ := utxos.Unspent("Alice"); len(points) != 1 || points[0].TxID != second.ID() {
+		t.Errorf("接入区块后 Alice 只应剩第二笔交易的找零: %v", points)
+	}
+	if err := utxos.DisconnectBlock(&block); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := utxos.Unspent("Alice"); !reflect.DeepEqual(before, after) {
+		t.Errorf("断开区块后应恢复原有输出: %v, %v", before, after)
+	}
+	if points, _ := utxos.Unspent("Bob"); len(points) != 1 {
+		t.Errorf("断开区块后应删除区块创建的输出: %v", points)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errFutureBlock 区块时间戳超前本地时间过多。本地时钟可能有偏差，区块之后可能变为有效，不惩罚发送方
+var errFutureBlock = errors.New("区块时间戳超前本地时间过多")
+
+// errUnknownSender 交易发送方的公钥不在本地账户中。新账户只登记在创建它的节点上，区块对其他节点可能有效，不惩罚发送方
+var errUnknownSender = errors.New("交易发送方公钥不存在")
+
+// isPeerFault 判断区块校验错误是否说明发送方有过错。时间戳超前和发送方公钥未知取决于本地时钟和账户，不算发送方的过错
+func isPeerFault(err error) bool {
+	return !errors.Is(err, errFutureBlock) && !errors.Is(err, errUnknownSender)
+}
+
+// ChainValidationError 描述链校验中第一个不合法的区块及原因
+type ChainValidationError struct {
+	Index int    // 区块在链中的位置
+	Hash  string // 区块声明的哈希
+	Err   error  // 不合法的原因
+}
+
+func (e *ChainValidationError) Error() string {
+	return fmt.Sprintf("区块 #%d (%s) 校验失败: %v", e.Index, e.Hash, e.Err)
+}
+
+func (e *ChainValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateChain 从创世区块开始逐块校验整条链
+func (bc *Blockchain) ValidateChain(publicKeys map[string]*ecdsa.PublicKey) error {
+	if len(bc.Blocks) == 0 {
+		return fmt.Errorf("区块链为空")
+	}
+	return bc.validateFrom(0, publicKeys)
+}
+
+// validateFrom 逐块校验 start 及之后的区块，start 之前的区块视为已通过校验，只重放其交易
+func (bc *Blockchain) validateFrom(start int, publicKeys map[string]*ecdsa.PublicKey) error {
+	l := newLedger(&bc.Blocks[0], bc.Params)
+	for i := range bc.Blocks[:start] {
+		l.applyBlock(&bc.Blocks[i])
+	}
+	for i := start; i < len(bc.Blocks); i++ {
+		block := &bc.Blocks[i]
+		var prev *Block
+		if i > 0 {
+			prev = &bc.Blocks[i-1]
+		}
+		if err := validateBlock(block, prev, bc.expectedBits(i), bc.medianTimePast(i), bc.Params, publicKeys); err != nil {
+			return &ChainValidationError{Index: i, Hash: block.Hash, Err: err}
+		}
+		if err := l.applyBlock(block); err != nil {
+			return &ChainValidationError{Index: i, Hash: block.Hash, Err: err}
+		}
+		if err := verifyStateRoot(block, l); err != nil {
+			return &ChainValidationError{Index: i, Hash: block.Hash, Err: err}
+		}
+	}
+	return nil
+}
+
+// verifyStateRoot 检查区块头中的状态根与执行该区块后账本的状态根一致，不包含状态根的旧版本区块不做检查
+func verifyStateRoot(block *Block, l *ledger) error {
+	if !block.Header.HasStateRoot() {
+		return nil
+	}
+	if root := l.stateRoot(); block.Header.StateRoot != root {
+		return fmt.Errorf("状态根不正确: 计算结果 %s, 区块记录 %s", root, block.Header.StateRoot)
+	}
+	return nil
+}
+
+// validateBlock 校验单个区块的链接、时间戳、难度目标、哈希、工作量证明、Merkle 根、奖励交易和签名，
+// bits 为该高度应使用的难度目标，medianTime 为此前区块时间戳的中位数
+func validateBlock(block, prev *Block, bits uint32, medianTime int64, params *ChainParams, publicKeys map[string]*ecdsa.PublicKey) error {
+	if prev == nil {
+		genesisPreviousHash := zeroHash
+		if block.Header.IsLegacy() {
+			genesisPreviousHash = "0"
+		}
+		if block.Header.Index != 0 || block.Header.PreviousHash != genesisPreviousHash {
+			return fmt.Errorf("创世区块的编号或前一区块哈希不正确")
+		}
+		// 新格式创世区块必须由创世配置生成，旧格式创世区块无法复现，只做常规校验
+		if !block.Header.IsLegacy() {
+			if err := validateGenesis(block, params); err != nil {
+				return err
+			}
+		}
+	}
+	if err := validateHeader(block, prev, bits, medianTime); err != nil {
+		return err
+	}
+
+	if root := CalculateMerkleRoot(block.Transactions); block.Header.MerkleRoot != root {
+		return fmt.Errorf("Merkle 根不正确: 计算结果 %s", root)
+	}
+
+	if len(block.Transactions) > maxBlockTxs {
+		return fmt.Errorf("区块交易数 %d 超过上限 %d", len(block.Transactions), maxBlockTxs)
+	}
+	size := 0
+	for _, tx := range block.Transactions {
+		size += tx.Size()
+	}
+	if size > maxBlockSize {
+		return fmt.Errorf("区块大小 %d 字节超过上限 %d 字节", size, maxBlockSize)
+	}
+
+	// 旧格式区块的奖励交易不受共识规则约束，创世区块的预分配交易已与创世配置比对
+	if !block.Header.IsLegacy() && prev != nil {
+		if err := validateCoinbase(block, params); err != nil {
+			return err
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		// 旧格式区块只能包含旧格式交易，新格式区块只接受网络当前使用的交易版本
+		if tx.IsLegacy() != block.Header.IsLegacy() {
+			return fmt.Errorf("交易版本 %d 与区块版本 %d 不匹配", tx.Version, block.Header.Version)
+		}
+		if !tx.IsLegacy() && !params.acceptsTxVersion(tx.Sender, tx.Version) {
+			return fmt.Errorf("网络不接受版本 %d 的交易", tx.Version)
+		}
+		// 旧格式交易的签名不包含手续费
+		if tx.IsLegacy() && tx.Fee != 0 {
+			return fmt.Errorf("旧格式交易不能包含手续费")
+		}
+		if tx.Sender == "System" {
+			continue
+		}
+		publicKey, exists := publicKeys[tx.Sender]
+		if !exists {
+			return fmt.Errorf("%w: %s", errUnknownSender, tx.Sender)
+		}
+		if !VerifyTransaction(&tx, params.ChainID, publicKey) {
+			return fmt.Errorf("交易签名无效: %s -> %s (金额: %s)", tx.Sender, tx.Receiver, tx.Amount)
+		}
+	}
+	return nil
+}
+
+// validateHeader 校验区块头的版本、与前一区块的链接、时间戳、难度目标、哈希和工作量证明，不需要区块体。
+// prev 为 nil 时表示创世区块，不检查链接和中位时间
+func validateHeader(block, prev *Block, bits uint32, medianTime int64) error {
+	if block.Header.Version > currentBlockVersion {
+		return fmt.Errorf("不支持的区块版本: %d", block.Header.Version)
+	}
+	if prev != nil {
+		if block.Header.Index != prev.Header.Index+1 {
+			return fmt.Errorf("区块编号不连续: 期望 %d, 实际 %d", prev.Header.Index+1, block.Header.Index)
+		}
+		if block.Header.PreviousHash != prev.Hash {
+			return fmt.Errorf("前一区块哈希不匹配: 期望 %s, 实际 %s", prev.Hash, block.Header.PreviousHash)
+		}
+		// 区块版本只能升级，旧版本区块只能出现在新版本区块之前
+		if block.Header.Version < prev.Header.Version {
+			return fmt.Errorf("区块版本 %d 不能低于前一区块的版本 %d", block.Header.Version, prev.Header.Version)
+		}
+	}
+	// 新格式区块必须使用当前版本，否则矿工可以在旧格式区块之后一直使用不承诺状态根的版本 1
+	if !block.Header.IsLegacy() && block.Header.Version != currentBlockVersion {
+		return fmt.Errorf("区块版本 %d 已不再使用，新格式区块必须使用版本 %d", block.Header.Version, currentBlockVersion)
+	}
+	// 时间戳参与难度调整：不能早于此前区块的中位时间，也不能远超本地时间。旧格式区块的时间戳不受约束
+	if prev != nil && !block.Header.IsLegacy() && block.Header.Timestamp <= medianTime {
+		return fmt.Errorf("区块时间戳 %d 不晚于此前 %d 个区块的中位时间 %d", block.Header.Timestamp, medianTimeBlocks, medianTime)
+	}
+	if limit := time.Now().Add(maxFutureBlockTime).Unix(); block.Header.Timestamp > limit {
+		return fmt.Errorf("%w: 时间戳 %d 超前本地时间超过 %v", errFutureBlock, block.Header.Timestamp, maxFutureBlockTime)
+	}
+	// 旧版本区块头不承诺状态根，不允许携带未受哈希保护的状态根
+	if !block.Header.HasStateRoot() && block.Header.StateRoot != "" {
+		return fmt.Errorf("区块版本 %d 不应记录状态根", block.Header.Version)
+	}
+
+	// 旧格式区块统一以固定难度挖出
+	if block.Header.IsLegacy() {
+		if block.Header.Bits != 0 {
+			return fmt.Errorf("旧格式区块不应记录难度目标")
+		}
+	} else if block.Header.Bits != bits {
+		return fmt.Errorf("区块难度目标不正确: 期望 0x%08x, 实际 0x%08x", bits, block.Header.Bits)
+	}
+
+	if hash := block.CalculateHash(); block.Hash != hash {
+		return fmt.Errorf("区块哈希不正确: 计算结果 %s", hash)
+	}
+	if !block.HasValidProofOfWork() {
+		return fmt.Errorf("区块哈希不满足难度目标 %x", block.Header.Target())
+	}
+	return nil
+}
+
+// validateNextBlock 校验区块能否接在当前链尾之后（当前链视为已通过校验）
+func (bc *Blockchain) validateNextBlock(block *Block, publicKeys map[string]*ecdsa.PublicKey) error {
+	if err := validateBlock(block, &bc.Blocks[len(bc.Blocks)-1], bc.NextBits(), bc.medianTimePast(len(bc.Blocks)), bc.Params, publicKeys); err != nil {
+		return err
+	}
+	l := bc.chainLedger()
+	if err := l.applyBlock(block); err != nil {
+		return err
+	}
+	return verifyStateRoot(block, l)
+}
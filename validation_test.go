@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"gamechain/account"
+	"gamechain/coin"
+	"testing"
+	"time"
+)
+
+// 单元测试使用的低难度网络参数，调整周期足够长，测试中不会触发难度调整
+var testParams = &ChainParams{
+	Name:             "unit",
+	ChainID:          "gamechain-unit",
+	InitialBits:      0x200fffff,
+	PowLimitBits:     0x207fffff,
+	RetargetInterval: 1000,
+	TargetBlockTime:  10,
+	RetargetFactor:   4,
+	InitialSubsidy:   50 * coin.Coin,
+	HalvingInterval:  1000,
+	MaxSupply:        1_000_000 * coin.Coin,
+	CoinbaseMaturity: 1,
+	GenesisTimestamp: 1735689600,
+	GenesisAlloc:     map[string]coin.Amount{"Alice": 100 * coin.Coin, "Bob": 100 * coin.Coin},
+}
+
+// newTestChain 生成一条包含创世区块的测试链以及 Alice、Bob 的密钥
+func newTestChain(t *testing.T) (*Blockchain, map[string]*ecdsa.PrivateKey, map[string]*ecdsa.PublicKey) {
+	t.Helper()
+	privateKeys := make(map[string]*ecdsa.PrivateKey)
+	publicKeys := make(map[string]*ecdsa.PublicKey)
+	for _, name := range []string{"Alice", "Bob"} {
+		privateKeys[name], publicKeys[name] = account.GenerateKeyPair()
+	}
+	bc := &Blockchain{Params: testParams}
+	bc.Blocks = append(bc.Blocks, newGenesisBlock(testParams))
+	return bc, privateKeys, publicKeys
+}
+
+// mineTestBlock 在链尾挖出一个包含给定交易的区块
+func mineTestBlock(bc *Blockchain, transactions []Transaction, miner string, publicKeys map[string]*ecdsa.PublicKey) Block {
+	block := bc.newBlockOnTip(transactions, miner, publicKeys)
+	block.ProofOfWork()
+	bc.Blocks = append(bc.Blocks, block)
+	return block
+}
+
+func TestValidateChainAcceptsValidChain(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	mineTestBlock(bc, []Transaction{NewTransaction(testParams.ChainID, "Alice", "Bob", 30*coin.Coin, 0, 1, privateKeys["Alice"])}, "Bob", publicKeys)
+	mineTestBlock(bc, []Transaction{NewTransaction(testParams.ChainID, "Bob", "Alice", 150*coin.Coin, 0, 1, privateKeys["Bob"])}, "Alice", publicKeys)
+
+	if err := bc.ValidateChain(publicKeys); err != nil {
+		t.Fatalf("合法链校验失败: %v", err)
+	}
+}
+
+func TestValidateChainRejectsInvalidBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey)
+		index  int
+	}{
+		{
+			name: "前一区块哈希断链",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				bc.Blocks[2].Header.PreviousHash = bc.Blocks[0].Hash
+			},
+			index: 2,
+		},
+		{
+			name: "区块哈希被篡改",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				bc.Blocks[1].Header.Nonce++
+			},
+			index: 1,
+		},
+		{
+			name: "交易被篡改",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				bc.Blocks[1].Transactions[0].Amount = 99 * coin.Coin
+			},
+			index: 1,
+		},
+		{
+			name: "签名无效",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				tx := NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, 0, 2, privateKeys["Bob"])
+				bc.Blocks = bc.Blocks[:2]
+				mineTestBlock(bc, []Transaction{tx}, "Bob", publicKeys)
+			},
+			index: 2,
+		},
+		{
+			name: "重放已上链的交易",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				replayed := bc.Blocks[1].Transactions[0]
+				bc.Blocks = bc.Blocks[:2]
+				mineTestBlock(bc, []Transaction{replayed}, "Bob", publicKeys)
+			},
+			index: 2,
+		},
+		{
+			name: "账户余额为负",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				tx := NewTransaction(testParams.ChainID, "Alice", "Bob", 500*coin.Coin, 0, 2, privateKeys["Alice"])
+				bc.Blocks = bc.Blocks[:2]
+				mineTestBlock(bc, []Transaction{tx}, "Bob", publicKeys)
+			},
+			index: 2,
+		},
+		{
+			name: "状态根不正确",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				bc.Blocks = bc.Blocks[:2]
+				block := bc.newBlockOnTip(nil, "Bob", publicKeys)
+				block.Header.StateRoot = bc.Blocks[1].Header.StateRoot
+				block.ProofOfWork()
+				bc.Blocks = append(bc.Blocks, block)
+			},
+			index: 2,
+		},
+		{
+			name: "时间戳不晚于中位时间",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				bc.Blocks = bc.Blocks[:2]
+				block := bc.newBlockOnTip(nil, "Bob", publicKeys)
+				block.Header.Timestamp = bc.medianTimePast(2)
+				block.ProofOfWork()
+				bc.Blocks = append(bc.Blocks, block)
+			},
+			index: 2,
+		},
+		{
+			name: "时间戳超前本地时间过多",
+			tamper: func(bc *Blockchain, privateKeys map[string]*ecdsa.PrivateKey, publicKeys map[string]*ecdsa.PublicKey) {
+				bc.Blocks = bc.Blocks[:2]
+				block := bc.newBlockOnTip(nil, "Bob", publicKeys)
+				block.Header.Timestamp = time.Now().Add(maxFutureBlockTime + time.Minute).Unix()
+				block.ProofOfWork()
+				bc.Blocks = append(bc.Blocks, block)
+			},
+			index: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, privateKeys, publicKeys := newTestChain(t)
+			mineTestBlock(bc, []Transaction{NewTransaction(testParams.ChainID, "Alice", "Bob", 30*coin.Coin, 0, 1, privateKeys["Alice"])}, "Bob", publicKeys)
+			mineTestBlock(bc, []Transaction{}, "Alice", publicKeys)
+			tt.tamper(bc, privateKeys, publicKeys)
+
+			var validationErr *ChainValidationError
+			if err := bc.ValidateChain(publicKeys); !errors.As(err, &validationErr) {
+				t.Fatalf("期望 ChainValidationError, 实际: %v", err)
+			}
+			if validationErr.Index != tt.index {
+				t.Errorf("期望第 %d 个区块校验失败, 实际: %v", tt.index, validationErr)
+			}
+		})
+	}
+}
+
+func TestValidateHeaderRequiresCurrentVersionAfterLegacy(t *testing.T) {
+	legacy := Block{Header: BlockHeader{Version: legacyBlockVersion, Timestamp: testParams.GenesisTimestamp, PreviousHash: "0"}}
+	legacy.Hash = legacy.CalculateHash()
+	next := func(version uint32, stateRoot string) Block {
+		block := Block{Header: BlockHeader{
+			Version:      version,
+			Index:        1,
+			Timestamp:    testParams.GenesisTimestamp + 1,
+			PreviousHash: legacy.Hash,
+			MerkleRoot:   zeroHash,
+			Bits:         testParams.InitialBits,
+			StateRoot:    stateRoot,
+		}}
+		block.ProofOfWork()
+		return block
+	}
+
+	// 版本 1 的区块头不承诺状态根，不能跟在旧格式区块之后
+	binary := next(binaryBlockVersion, "")
+	if err := validateHeader(&binary, &legacy, testParams.InitialBits, legacy.Header.Timestamp); err == nil {
+		t.Error("旧格式区块之后的版本 1 区块应校验失败")
+	}
+	current := next(currentBlockVersion, zeroHash)
+	if err := validateHeader(&current, &legacy, testParams.InitialBits, legacy.Header.Timestamp); err != nil {
+		t.Errorf("旧格式区块之后的当前版本区块应通过校验: %v", err)
+	}
+}
+
+func TestAddTransactionToPoolEnforcesNonce(t *testing.T) {
+	bc, privateKeys, publicKeys := newTestChain(t)
+	poolFile := t.TempDir() + "/pool.json"
+
+	first := NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, 0, 1, privateKeys["Alice"])
+	if !bc.AddTransactionToPool(first, publicKeys, poolFile) {
+		t.Fatal("nonce 为 1 的交易应被接受")
+	}
+	if bc.AddTransactionToPool(first, publicKeys, poolFile) {
+		t.Error("重复的交易应被拒绝")
+	}
+	if bc.AddTransactionToPool(NewTransaction(testParams.ChainID, "Alice", "Bob", 10*coin.Coin, 0, 3, privateKeys["Alice"]), publicKeys, poolFile) {
+		t.Error("跳过 nonce 的交易应被拒绝")
+	}
+	if next := bc.NextNonce("Alice"); next != 2 {
+		t.Errorf("期望下一个 nonce 为 2, 实际 %d", next)
+	}
+
+	mineTestBlock(bc, bc.TransactionPool, "Bob", publicKeys)
+	bc.ClearTransactionPool(bc.Blocks[1].Transactions)
+	if bc.AddTransactionToPool(first, publicKeys, poolFile) {
+		t.Error("已上链的交易不应再次进入交易池")
+	}
+	if next := bc.NextNonce("Alice"); next != 2 {
+		t.Errorf("打包后下一个 nonce 应为 2, 实际 %d", next)
+	}
+}
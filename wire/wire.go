@@ -0,0 +1,148 @@
+// Package wire 实现节点之间的消息帧编码。
+//
+// 每条消息由固定长度的帧头和负载组成，整数均为大端序：
+//
+//	Magic(4) | Version(4) | Command(12) | Length(4) | Checksum(4) | Payload(Length)
+//
+// Magic 区分不同的网络，Command 为右侧以 0 填充的 ASCII 命令名，
+// Checksum 为负载两次 SHA-256 后的前 4 字节。负载为消息结构体的 JSON 编码
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	CommandSize    = 12                 // 帧头中命令名的字节数
+	HeaderSize     = 4 + 4 + 12 + 4 + 4 // 帧头的字节数
+	MaxPayloadSize = 32 << 20           // 单条消息负载的字节数上限
+)
+
+var (
+	ErrBadMagic        = errors.New("网络标识不匹配")
+	ErrBadCommand      = errors.New("命令名无效")
+	ErrPayloadTooLarge = errors.New("消息负载过大")
+	ErrBadChecksum     = errors.New("消息校验和不正确")
+)
+
+// Message 一条已解帧的消息
+type Message struct {
+	Version uint32 // 发送方使用的协议版本
+	Command string
+	Payload []byte
+}
+
+// checksum 返回负载两次 SHA-256 后的前 4 字节
+func checksum(payload []byte) [4]byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	var sum [4]byte
+	copy(sum[:], second[:4])
+	return sum
+}
+
+// validCommand 判断命令名是否为 1 到 CommandSize 个可打印 ASCII 字符
+func validCommand(command string) bool {
+	if len(command) == 0 || len(command) > CommandSize {
+		return false
+	}
+	for i := 0; i < len(command); i++ {
+		if command[i] < 0x21 || command[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteMessage 将消息编码为一帧写入 w
+func WriteMessage(w io.Writer, magic uint32, msg Message) error {
+	if !validCommand(msg.Command) {
+		return fmt.Errorf("%w: %q", ErrBadCommand, msg.Command)
+	}
+	if len(msg.Payload) > MaxPayloadSize {
+		return fmt.Errorf("%w: %d 字节", ErrPayloadTooLarge, len(msg.Payload))
+	}
+
+	frame := make([]byte, HeaderSize, HeaderSize+len(msg.Payload))
+	binary.BigEndian.PutUint32(frame[0:4], magic)
+	binary.BigEndian.PutUint32(frame[4:8], msg.Version)
+	copy(frame[8:20], msg.Command)
+	binary.BigEndian.PutUint32(frame[20:24], uint32(len(msg.Payload)))
+	sum := checksum(msg.Payload)
+	copy(frame[24:28], sum[:])
+	frame = append(frame, msg.Payload...)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadMessage 从 r 读取一帧并校验网络标识、命令名、长度和校验和，负载不超过 MaxPayloadSize。
+// 协议版本由调用方检查
+func ReadMessage(r io.Reader, magic uint32) (Message, error) {
+	return ReadMessageLimit(r, magic, MaxPayloadSize)
+}
+
+// ReadMessageLimit 与 ReadMessage 相同，但负载不能超过 maxSize 字节（最多 MaxPayloadSize）。
+// 帧头中的长度未经认证，负载按实际收到的字节逐步扩展缓冲区，不预先按声明的长度分配内存
+func ReadMessageLimit(r io.Reader, magic, maxSize uint32) (Message, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+	if got := binary.BigEndian.Uint32(header[0:4]); got != magic {
+		return Message{}, fmt.Errorf("%w: 0x%08x", ErrBadMagic, got)
+	}
+
+	// 命令名之后只能是填充的 0
+	name := header[8:20]
+	end := bytes.IndexByte(name, 0)
+	if end < 0 {
+		end = CommandSize
+	}
+	command := string(name[:end])
+	if !validCommand(command) || bytes.IndexFunc(name[end:], func(r rune) bool { return r != 0 }) >= 0 {
+		return Message{}, fmt.Errorf("%w: %q", ErrBadCommand, name)
+	}
+
+	length := binary.BigEndian.Uint32(header[20:24])
+	if length > min(maxSize, MaxPayloadSize) {
+		return Message{}, fmt.Errorf("%w: %d 字节", ErrPayloadTooLarge, length)
+	}
+	payload, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return Message{}, err
+	}
+	if len(payload) < int(length) {
+		return Message{}, io.ErrUnexpectedEOF
+	}
+	if sum := checksum(payload); !bytes.Equal(sum[:], header[24:28]) {
+		return Message{}, ErrBadChecksum
+	}
+	return Message{
+		Version: binary.BigEndian.Uint32(header[4:8]),
+		Command: command,
+		Payload: payload,
+	}, nil
+}
+
+// Encode 将 v 编码为 JSON 负载并作为 command 消息写入 w
+func Encode(w io.Writer, magic, version uint32, command string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("编码 %s 消息失败: %w", command, err)
+	}
+	return WriteMessage(w, magic, Message{Version: version, Command: command, Payload: payload})
+}
+
+// Decode 将消息的 JSON 负载解码到 v
+func (m Message) Decode(v any) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("解析 %s 消息失败: %w", m.Command, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+const testMagic = 0x0b110907
+
+func encodeFrame(t testing.TB, msg Message) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, testMagic, msg); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testMagic, 1, "tx", map[string]int{"Amount": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMessage(&buf, testMagic, Message{Version: 2, Command: "getchain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := ReadMessage(&buf, testMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]int
+	if err := msg.Decode(&payload); err != nil || msg.Version != 1 || msg.Command != "tx" || payload["Amount"] != 7 {
+		t.Errorf("第一条消息不正确: %+v, %v", msg, err)
+	}
+	// 帧头记录了负载长度，同一连接上的消息不会粘连
+	msg, err = ReadMessage(&buf, testMagic)
+	if err != nil || msg.Version != 2 || msg.Command != "getchain" || len(msg.Payload) != 0 {
+		t.Errorf("第二条消息不正确: %+v, %v", msg, err)
+	}
+	if _, err := ReadMessage(&buf, testMagic); err != io.EOF {
+		t.Errorf("读完后应返回 io.EOF, 实际: %v", err)
+	}
+}
+
+func TestReadMessageRejectsCorruptFrames(t *testing.T) {
+	valid := encodeFrame(t, Message{Version: 1, Command: "block", Payload: []byte(`{"Hash":"00"}`)})
+	corrupt := func(offset int, value byte) []byte {
+		frame := append([]byte(nil), valid...)
+		frame[offset] = value
+		return frame
+	}
+	tests := map[string]struct {
+		frame []byte
+		want  error
+	}{
+		"网络标识不同":   {corrupt(0, 0xff), ErrBadMagic},
+		"命令名中间有 0": {corrupt(9, 0), ErrBadCommand},
+		"命令名填充非 0": {corrupt(19, 'x'), ErrBadCommand},
+		"命令名不可打印":  {corrupt(8, '\n'), ErrBadCommand},
+		"负载过大":     {corrupt(20, 0xff), ErrPayloadTooLarge},
+		"负载被修改":    {corrupt(HeaderSize+2, 'X'), ErrBadChecksum},
+		"负载被截断":    {valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		"帧头被截断":    {valid[:HeaderSize-1], io.ErrUnexpectedEOF},
+	}
+	for name, test := range tests {
+		if _, err := ReadMessage(bytes.NewReader(test.frame), testMagic); !errors.Is(err, test.want) {
+			t.Errorf("%s: 期望 %v, 实际 %v", name, test.want, err)
+		}
+	}
+}
+
+func TestReadMessageLimit(t *testing.T) {
+	frame := encodeFrame(t, Message{Version: 1, Command: "version", Payload: bytes.Repeat([]byte("a"), 100)})
+	if _, err := ReadMessageLimit(bytes.NewReader(frame), testMagic, 99); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("超过上限的负载应被拒绝, 实际: %v", err)
+	}
+	if msg, err := ReadMessageLimit(bytes.NewReader(frame), testMagic, 100); err != nil || len(msg.Payload) != 100 {
+		t.Errorf("不超过上限的负载应被接受: %v", err)
+	}
+
+	// 声明最大长度但只发送帧头，不应按声明的长度分配内存
+	header := append([]byte(nil), frame[:HeaderSize]...)
+	binary.BigEndian.PutUint32(header[20:24], MaxPayloadSize)
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	before := stats.TotalAlloc
+	if _, err := ReadMessage(bytes.NewReader(header), testMagic); err != io.ErrUnexpectedEOF {
+		t.Errorf("负载被截断时应返回 io.ErrUnexpectedEOF, 实际: %v", err)
+	}
+	runtime.ReadMemStats(&stats)
+	if allocated := stats.TotalAlloc - before; allocated >= 1<<20 {
+		t.Errorf("只收到帧头时分配了 %d 字节", allocated)
+	}
+}
+
+func TestWriteMessageRejectsInvalidCommand(t *testing.T) {
+	for _, command := range []string{"", "thirteenchars", "get block"} {
+		if err := WriteMessage(io.Discard, testMagic, Message{Command: command}); !errors.Is(err, ErrBadCommand) {
+			t.Errorf("命令名 %q: 期望 ErrBadCommand, 实际 %v", command, err)
+		}
+	}
+}
+
+// FuzzReadMessage 任意输入都不能使解码崩溃，成功解码的帧重新编码后与原始字节一致
+func FuzzReadMessage(f *testing.F) {
+	f.Add(encodeFrame(f, Message{Version: 1, Command: "tx", Payload: []byte(`{}`)}))
+	f.Add(encodeFrame(f, Message{Version: 1, Command: "getchain"}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := ReadMessage(bytes.NewReader(data), testMagic)
+		if err != nil {
+			return
+		}
+		frame := encodeFrame(t, msg)
+		if !bytes.Equal(frame, data[:len(frame)]) {
+			t.Errorf("重新编码的帧与输入不一致: %x, %x", frame, data)
+		}
+	})
+}
+
+// FuzzRoundTrip 任意合法的命令和负载编码后都能原样解码
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(uint32(1), "block", []byte(`{"Block":{}}`))
+	f.Add(uint32(0), "x", []byte{0, 1, 2})
+	f.Fuzz(func(t *testing.T, version uint32, command string, payload []byte) {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, testMagic, Message{Version: version, Command: command, Payload: payload}); err != nil {
+			if validCommand(command) {
+				t.Fatalf("合法命令 %q 编码失败: %v", command, err)
+			}
+			return
+		}
+		msg, err := ReadMessage(&buf, testMagic)
+		if err != nil {
+			t.Fatalf("解码失败: %v", err)
+		}
+		if msg.Version != version || msg.Command != command || !bytes.Equal(msg.Payload, payload) {
+			t.Errorf("解码结果不一致: %+v", msg)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+	"time"
+)
+
+// 区块时间戳规则，限制矿工通过伪造时间戳影响难度调整
+const (
+	medianTimeBlocks   = 11            // 新区块的时间戳必须晚于此前这么多个区块时间戳的中位数
+	maxFutureBlockTime = 2 * time.Hour // 区块时间戳最多超前本地时间的时长
+)
+
+// 哈希空间大小 2^256
+var hashSpace = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// 旧格式区块要求哈希前两位十六进制为 0，等价于目标值 2^248 - 1
+var legacyTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 248), big.NewInt(1))
+
+// CompactToBig 将紧凑格式（与比特币 nBits 相同）的难度目标解码为 256 位整数。
+// 最高字节为以字节计的长度，低 23 位为尾数，第 24 位为符号位
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		target = big.NewInt(int64(mantissa >> (8 * (3 - exponent))))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if compact&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// BigToCompact 将难度目标编码为紧凑格式，只保留最高的 3 个字节
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	abs := new(big.Int).Abs(target)
+	exponent := uint(len(abs.Bytes()))
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(abs.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(abs, 8*(exponent-3)).Uint64())
+	}
+	// 尾数最高位是符号位，被占用时整体右移一个字节
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// HashToBig 将十六进制的区块哈希解析为 256 位整数，格式错误时返回 nil
+func HashToBig(hash string) *big.Int {
+	if len(hash) != 64 {
+		return nil
+	}
+	n, ok := new(big.Int).SetString(hash, 16)
+	if !ok {
+		return nil
+	}
+	return n
+}
+
+// blockWork 返回满足给定目标的区块所代表的期望哈希次数 2^256 / (target + 1)
+func blockWork(target *big.Int) *big.Int {
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Div(hashSpace, new(big.Int).Add(target, big.NewInt(1)))
+}
+
+// TotalWork 返回链上所有区块的累计工作量
+func (bc *Blockchain) TotalWork() *big.Int {
+	total := new(big.Int)
+	for _, block := range bc.Blocks {
+		total.Add(total, blockWork(block.Header.Target()))
+	}
+	return total
+}
+
+// HasMoreWorkThan 判断当前链是否应取代 other：累计工作量更大者胜出，
+// 工作量相同时链尾哈希较小者胜出，保证所有节点得出相同的选择
+func (bc *Blockchain) HasMoreWorkThan(other *Blockchain) bool {
+	if cmp := bc.TotalWork().Cmp(other.TotalWork()); cmp != 0 {
+		return cmp > 0
+	}
+	if len(bc.Blocks) == 0 || len(other.Blocks) == 0 {
+		return false
+	}
+	return bc.Blocks[len(bc.Blocks)-1].Hash < other.Blocks[len(other.Blocks)-1].Hash
+}
+
+// NextBits 返回下一个区块应使用的紧凑格式难度目标
+func (bc *Blockchain) NextBits() uint32 {
+	return bc.expectedBits(len(bc.Blocks))
+}
+
+// expectedBits 根据 height 之前的区块计算该高度区块应使用的难度目标。
+// 每 RetargetInterval 个区块按实际耗时与期望耗时的比例缩放目标值，
+// 比例限制在 [1/RetargetFactor, RetargetFactor] 内，且目标值不超过 PowLimitBits
+func (bc *Blockchain) expectedBits(height int) uint32 {
+	params := bc.Params
+	if height == 0 {
+		return params.InitialBits
+	}
+
+	prev := bc.Blocks[height-1].Header
+	if height%params.RetargetInterval != 0 || height < params.RetargetInterval {
+		if prev.IsLegacy() {
+			return BigToCompact(legacyTarget)
+		}
+		return prev.Bits
+	}
+
+	first := bc.Blocks[height-params.RetargetInterval].Header
+	actual := prev.Timestamp - first.Timestamp
+	expected := int64(params.RetargetInterval-1) * params.TargetBlockTime
+	if actual*params.RetargetFactor < expected {
+		actual, expected = 1, params.RetargetFactor
+	} else if actual > expected*params.RetargetFactor {
+		actual, expected = params.RetargetFactor, 1
+	}
+
+	target := new(big.Int).Mul(prev.Target(), big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if powLimit := CompactToBig(params.PowLimitBits); target.Cmp(powLimit) > 0 {
+		target = powLimit
+	}
+	return BigToCompact(target)
+}
+
+// medianTimePast 返回 height 之前最多 medianTimeBlocks 个区块时间戳的中位数，height 处的区块时间戳必须大于该值
+func (bc *Blockchain) medianTimePast(height int) int64 {
+	var timestamps []int64
+	for i := max(0, height-medianTimeBlocks); i < height; i++ {
+		timestamps = append(timestamps, bc.Blocks[i].Header.Timestamp)
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// chainWithBits 构造一条每个区块记录给定难度目标的链
+func chainWithBits(bits ...uint32) *Blockchain {
+	bc := &Blockchain{Params: testParams}
+	for i, b := range bits {
+		bc.Blocks = append(bc.Blocks, Block{Header: BlockHeader{Version: currentBlockVersion, Index: i, Bits: b}})
+	}
+	return bc
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		hex     string
+	}{
+		{0x1d00ffff, "ffff" + strings.Repeat("00", 26)},
+		{0x2000ffff, "ffff" + strings.Repeat("00", 29)},
+		{0x200fffff, "fffff" + strings.Repeat("00", 29)},
+		{0x03123456, "123456"},
+		{0x02008000, "80"},
+	}
+	for _, tt := range tests {
+		target := CompactToBig(tt.compact)
+		if target.Text(16) != tt.hex {
+			t.Errorf("0x%08x 解码结果 %s, 期望 %s", tt.compact, target.Text(16), tt.hex)
+		}
+		if got := BigToCompact(target); got != tt.compact {
+			t.Errorf("%s 编码结果 0x%08x, 期望 0x%08x", tt.hex, got, tt.compact)
+		}
+	}
+
+	if got := BigToCompact(legacyTarget); got != 0x2000ffff {
+		t.Errorf("旧格式难度目标应编码为 0x2000ffff, 实际 0x%08x", got)
+	}
+}
+
+func TestHasMoreWorkThanPrefersWorkOverLength(t *testing.T) {
+	long := chainWithBits(0x200fffff, 0x200fffff, 0x200fffff, 0x200fffff, 0x200fffff, 0x200fffff)
+	heavy := chainWithBits(0x200fffff, 0x2000ffff)
+
+	if !heavy.HasMoreWorkThan(long) {
+		t.Errorf("工作量 %s 的链应胜过工作量 %s 的更长链", heavy.TotalWork(), long.TotalWork())
+	}
+	if long.HasMoreWorkThan(heavy) {
+		t.Error("低难度的长链不应胜出")
+	}
+}
+
+func TestHasMoreWorkThanBreaksTiesByTipHash(t *testing.T) {
+	a := chainWithBits(0x200fffff, 0x200fffff)
+	b := chainWithBits(0x200fffff, 0x200fffff)
+	a.Blocks[1].Hash, b.Blocks[1].Hash = "01", "02"
+
+	if !a.HasMoreWorkThan(b) || b.HasMoreWorkThan(a) {
+		t.Error("工作量相同时应由链尾哈希较小的链胜出")
+	}
+	if a.HasMoreWorkThan(a) {
+		t.Error("链不应胜过自身")
+	}
+}
+
+func TestExpectedBitsRetargets(t *testing.T) {
+	params := &ChainParams{
+		InitialBits:      0x1f7fff00,
+		PowLimitBits:     0x2000ffff,
+		RetargetInterval: 4,
+		TargetBlockTime:  10,
+		RetargetFactor:   4,
+	}
+	base := CompactToBig(params.InitialBits)
+	scaled := func(num, den int64) uint32 {
+		target := new(big.Int).Mul(base, big.NewInt(num))
+		return BigToCompact(target.Div(target, big.NewInt(den)))
+	}
+	tests := []struct {
+		name     string
+		spacing  int64
+		expected uint32
+	}{
+		{"出块速度正常时保持难度", 10, 0x1f7fff00},
+		{"出块稍快时按比例缩小目标", 5, scaled(1, 2)},
+		{"出块稍慢时按比例放大目标", 20, scaled(2, 1)},
+		{"单次调整最多缩小 4 倍", 0, scaled(1, 4)},
+		{"单次调整最多放大 4 倍且不超过最低难度", 1000, 0x2000ffff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := &Blockchain{Params: params}
+			for i := 0; i < params.RetargetInterval; i++ {
+				bc.Blocks = append(bc.Blocks, Block{Header: BlockHeader{
+					Version:   currentBlockVersion,
+					Index:     i,
+					Timestamp: int64(i) * tt.spacing,
+					Bits:      params.InitialBits,
+				}})
+			}
+			if got := bc.expectedBits(params.RetargetInterval - 1); got != params.InitialBits {
+				t.Errorf("调整周期内难度目标应保持 0x%08x, 实际 0x%08x", params.InitialBits, got)
+			}
+			if got := bc.NextBits(); got != tt.expected {
+				t.Errorf("期望难度目标 0x%08x, 实际 0x%08x", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	bc := &Blockchain{Params: testParams}
+	for i, timestamp := range []int64{100, 50, 300, 200, 10, 20, 30, 40, 60, 70, 80, 90, 400} {
+		bc.Blocks = append(bc.Blocks, Block{Header: BlockHeader{Index: i, Timestamp: timestamp}})
+	}
+	if got := bc.medianTimePast(3); got != 100 {
+		t.Errorf("前 3 个区块的中位时间应为 100, 实际 %d", got)
+	}
+	// 只取 #1 到 #11 这 11 个区块
+	if got := bc.medianTimePast(12); got != 60 {
+		t.Errorf("最近 11 个区块的中位时间应为 60, 实际 %d", got)
+	}
+}